@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+// schemePorts are the default ports for the schemes found in service credentials
+var schemePorts = map[string]int{
+	"http":       80,
+	"https":      443,
+	"postgres":   5432,
+	"postgresql": 5432,
+	"mysql":      3306,
+	"redis":      6379,
+	"rediss":     6379,
+	"amqp":       5672,
+	"amqps":      5671,
+	"mongodb":    27017,
+	"sqlserver":  1433,
+}
+
+type appEnv struct {
+	SystemEnv struct {
+		VCAPServices map[string][]serviceInstance `json:"VCAP_SERVICES"`
+	} `json:"system_env_json"`
+}
+
+type serviceInstance struct {
+	Name        string                 `json:"name"`
+	Credentials map[string]interface{} `json:"credentials"`
+}
+
+type serviceEndpoint struct {
+	host string
+	port int
+}
+
+// appRequests builds a request for every host and port found in the credentials of the services bound to options.app
+func (c *WillItConnect) appRequests(cliConnection plugin.CliConnection, options *wicOptions) ([]*wicRequest, []string) {
+	app, err := cliConnection.GetApp(options.app)
+	if err != nil {
+		return nil, []string{"Unable to find app " + options.app + ", please view cf apps"}
+	}
+
+	output, err := cliConnection.CliCommandWithoutTerminalOutput("curl", "/v2/apps/"+app.Guid+"/env")
+	if err != nil {
+		return nil, []string{"Unable to read the environment of app " + options.app + ": ", err.Error()}
+	}
+
+	var env appEnv
+	if err := json.Unmarshal([]byte(strings.Join(output, "\n")), &env); err != nil {
+		return nil, []string{"Invalid environment for app " + options.app + ": ", err.Error()}
+	}
+
+	var labels []string
+	for label := range env.SystemEnv.VCAPServices {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var requests []*wicRequest
+	for _, label := range labels {
+		for _, instance := range env.SystemEnv.VCAPServices[label] {
+			endpoints := credentialEndpoints(instance.Credentials)
+			if len(endpoints) == 0 {
+				fmt.Fprintln(os.Stderr, "Skipping service "+instance.Name+", no host and port found in its credentials")
+			}
+			for _, endpoint := range endpoints {
+				request := newRequest(endpoint.host, endpoint.port, options.proxy)
+				request.name = instance.Name
+				requests = append(requests, request)
+			}
+		}
+	}
+
+	if len(requests) == 0 {
+		return nil, []string{"App " + options.app + " has no bound services with a host and port"}
+	}
+	return requests, nil
+}
+
+// credentialEndpoints extracts the hosts and ports from the common credential shapes:
+// uri, url, uris, jdbcUrl, hostname or host with port, and a hosts array with port
+func credentialEndpoints(credentials map[string]interface{}) []serviceEndpoint {
+	var endpoints []serviceEndpoint
+	add := func(found ...serviceEndpoint) {
+		for _, endpoint := range found {
+			duplicate := false
+			for _, existing := range endpoints {
+				if existing == endpoint {
+					duplicate = true
+				}
+			}
+			if !duplicate {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+	}
+
+	for _, key := range []string{"uri", "url", "jdbcUrl"} {
+		if uri, ok := credentials[key].(string); ok {
+			add(uriEndpoints(uri)...)
+		}
+	}
+	if uris, ok := credentials["uris"].([]interface{}); ok {
+		for _, uri := range uris {
+			if uri, ok := uri.(string); ok {
+				add(uriEndpoints(uri)...)
+			}
+		}
+	}
+
+	port, hasPort := credentialPort(credentials["port"])
+	if !hasPort {
+		return endpoints
+	}
+	for _, key := range []string{"hostname", "host"} {
+		if host, ok := credentials[key].(string); ok && host != "" {
+			add(serviceEndpoint{host, port})
+		}
+	}
+	if hosts, ok := credentials["hosts"].([]interface{}); ok {
+		for _, host := range hosts {
+			if host, ok := host.(string); ok && host != "" {
+				add(serviceEndpoint{host, port})
+			}
+		}
+	}
+	return endpoints
+}
+
+func credentialPort(value interface{}) (int, bool) {
+	switch port := value.(type) {
+	case float64:
+		parsed, err := parsePort(strconv.FormatFloat(port, 'f', -1, 64))
+		return parsed, err == nil
+	case string:
+		parsed, err := parsePort(port)
+		return parsed, err == nil
+	}
+	return -1, false
+}
+
+// uriEndpoints returns the hosts and ports of a connection uri, which may list several comma separated hosts
+// as mongodb uris do. Ports default by scheme, and http and https uris are checked as parseAddress reads them,
+// keeping their scheme and path so willitconnect makes an http connection.
+func uriEndpoints(uri string) []serviceEndpoint {
+	scheme, hostPorts := splitServiceURI(uri)
+	if scheme == "http" || scheme == "https" {
+		if host, port, err := parseAddress(uri, -1); err == nil {
+			return []serviceEndpoint{{host, port}}
+		}
+		return nil
+	}
+
+	var endpoints []serviceEndpoint
+	for _, hostPort := range hostPorts {
+		host, port, err := parseAddress(hostPort, -1)
+		if err != nil {
+			continue
+		}
+		if defaultPort, ok := schemePorts[scheme]; ok && port == -1 {
+			port = defaultPort
+		}
+		if host == "" || port == -1 {
+			continue
+		}
+		endpoints = append(endpoints, serviceEndpoint{host, port})
+	}
+	return endpoints
+}
+
+// splitServiceURI returns the lower cased scheme of a connection uri, which may have a jdbc: prefix, and the
+// host[:port] entries of its authority with any credentials removed. The scheme is empty when uri isn't a uri.
+func splitServiceURI(uri string) (string, []string) {
+	if strings.HasPrefix(strings.ToLower(uri), "jdbc:") {
+		uri = uri[len("jdbc:"):]
+	}
+	separator := strings.Index(uri, "://")
+	if separator < 1 {
+		return "", nil
+	}
+	scheme := strings.ToLower(uri[:separator])
+	authority := uri[separator+3:]
+	if end := strings.IndexAny(authority, "/?#;"); end != -1 {
+		authority = authority[:end]
+	}
+	if at := strings.LastIndex(authority, "@"); at != -1 {
+		authority = authority[at+1:]
+	}
+	return scheme, strings.Split(authority, ",")
+}
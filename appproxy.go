@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+// appProxyEnv is the proxy environment an app runs with: its own environment variables over the running
+// environment variable group. Each layer is kept apart, so the app's http_proxy beats the group's HTTP_PROXY.
+type appProxyEnv struct {
+	layers []proxyEnvLayer
+}
+
+// proxyEnvLayer is the proxy variables from one source
+type proxyEnvLayer struct {
+	vars   map[string]string
+	source string
+}
+
+// readAppProxyEnv reads the proxy environment of an app from its environment variables and the running
+// environment variable group
+func (c *WillItConnect) readAppProxyEnv(cliConnection plugin.CliConnection, name string) (*appProxyEnv, []string) {
+	app, err := cliConnection.GetApp(name)
+	if err != nil {
+		return nil, []string{"Unable to find app " + name + ", please view cf apps"}
+	}
+	output, err := cliConnection.CliCommandWithoutTerminalOutput("running-environment-variable-group")
+	if err != nil {
+		return nil, []string{"Unable to read the running environment variable group: ", err.Error()}
+	}
+
+	appLayer := proxyEnvLayer{vars: map[string]string{}, source: "the environment of app " + name}
+	for variable, value := range app.EnvironmentVars {
+		if value, ok := value.(string); ok {
+			appLayer.set(variable, value)
+		}
+	}
+	groupLayer := proxyEnvLayer{vars: map[string]string{}, source: "the running environment variable group"}
+	for variable, value := range parseEnvironmentVariableGroup(output) {
+		groupLayer.set(variable, value)
+	}
+	return &appProxyEnv{layers: []proxyEnvLayer{appLayer, groupLayer}}, nil
+}
+
+// parseEnvironmentVariableGroup reads the variables from the table cf running-environment-variable-group prints
+func parseEnvironmentVariableGroup(output []string) map[string]string {
+	vars := map[string]string{}
+	table := false
+	for _, line := range output {
+		fields := strings.Fields(line)
+		if !table {
+			table = strings.EqualFold(strings.Join(fields, " "), "variable name assigned value")
+			continue
+		}
+		if len(fields) > 1 {
+			vars[fields[0]] = strings.Join(fields[1:], " ")
+		}
+	}
+	return vars
+}
+
+func (l *proxyEnvLayer) set(variable string, value string) {
+	for _, names := range [][]string{httpProxyEnvs, httpsProxyEnvs, noProxyEnvs} {
+		for _, name := range names {
+			if variable == name {
+				l.vars[variable] = value
+			}
+		}
+	}
+}
+
+// lookup returns the first of the variables the app has, its value and where it came from. The app's own
+// environment is searched for all of the names before the group is.
+func (e *appProxyEnv) lookup(names ...string) (string, string, string) {
+	for _, layer := range e.layers {
+		for _, name := range names {
+			if value := layer.vars[name]; value != "" {
+				return name, value, layer.source
+			}
+		}
+	}
+	return "", "", ""
+}
+
+// proxyFor returns the proxy the app would use for a target: HTTP_PROXY or HTTPS_PROXY for a url's scheme. It
+// returns nil for socket targets, since database and message broker clients don't use these variables, and
+// when the app has no proxy or NO_PROXY matches the target.
+func (e *appProxyEnv) proxyFor(host string, port int) (*targetProxy, error) {
+	if !strings.Contains(host, "://") {
+		return nil, nil
+	}
+	parsed, err := url.Parse(host)
+	if err != nil {
+		return nil, nil
+	}
+	var names []string
+	switch parsed.Scheme {
+	case "http":
+		names = httpProxyEnvs
+	case "https":
+		names = httpsProxyEnvs
+	default:
+		return nil, nil
+	}
+	name, value, source := e.lookup(names...)
+	if value == "" {
+		return nil, nil
+	}
+	proxy, err := parseTargetProxy(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s in %s: %v", name, source, err)
+	}
+	_, proxy.noProxy, _ = e.lookup(noProxyEnvs...)
+	if proxy.bypasses(host, port) {
+		return nil, nil
+	}
+	return proxy, nil
+}
+
+// describe lists the app's proxy variables and where they came from, masking any password
+func (e *appProxyEnv) describe(app string) []string {
+	var lines []string
+	for _, layer := range e.layers {
+		var names []string
+		for name := range layer.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := layer.vars[name]
+			if proxyURL, err := parseProxyURL(value); err == nil && proxyURL.User != nil {
+				value = proxyURL.Redacted()
+			}
+			lines = append(lines, "App "+app+" has "+name+"="+value+" from "+layer.source)
+		}
+	}
+	if len(lines) == 0 {
+		return []string{"App " + app + " has no HTTP_PROXY, HTTPS_PROXY or NO_PROXY, checking without a proxy"}
+	}
+	return append(lines, "Only http and https urls use these, other targets are checked directly as app "+app+" would connect")
+}
+
+// applyAppProxy sends each url request without a proxy of its own through the proxy options.asApp would use for it
+func (c *WillItConnect) applyAppProxy(cliConnection plugin.CliConnection, options *wicOptions) []string {
+	env, envErr := c.readAppProxyEnv(cliConnection, options.asApp)
+	if envErr != nil {
+		return envErr
+	}
+	for _, line := range env.describe(options.asApp) {
+		options.notify(line)
+	}
+	for _, request := range options.requests {
+		if request.proxy != nil {
+			continue
+		}
+		port, _ := strconv.Atoi(request.port)
+		proxy, err := env.proxyFor(request.host, port)
+		if err != nil {
+			return []string{"Unable to use the proxy of app " + options.asApp + ": ", err.Error()}
+		}
+		request.proxy = proxy
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+)
+
+// asgRule is a single rule of an application security group
+type asgRule struct {
+	group       string
+	index       int
+	protocol    string
+	destination string
+	ports       string
+}
+
+func (r asgRule) String() string {
+	rule := r.protocol + " " + r.destination
+	if r.ports != "" {
+		rule += " " + r.ports
+	}
+	return fmt.Sprintf("%s rule %d (%s)", r.group, r.index, rule)
+}
+
+// asgReport is the offline evaluation of a target against the space's security groups
+type asgReport struct {
+	Permitted bool         `json:"permitted" yaml:"permitted"`
+	Verdicts  []asgVerdict `json:"verdicts" yaml:"verdicts"`
+}
+
+type asgVerdict struct {
+	IP        string `json:"ip" yaml:"ip"`
+	Port      int    `json:"port" yaml:"port"`
+	Permitted bool   `json:"permitted" yaml:"permitted"`
+	Rule      string `json:"rule" yaml:"rule"`
+	Error     string `json:"error" yaml:"error"`
+}
+
+type runningSecurityGroups struct {
+	Resources []struct {
+		Entity struct {
+			Name  string                   `json:"name"`
+			Rules []map[string]interface{} `json:"rules"`
+		} `json:"entity"`
+	} `json:"resources"`
+}
+
+// spaceASGRules returns the rules of the security groups bound to the current space and the running default groups
+func (c *WillItConnect) spaceASGRules(cliConnection plugin.CliConnection) ([]asgRule, []string) {
+	currSpace, err := cliConnection.GetCurrentSpace()
+	if (err != nil || currSpace == plugin_models.Space{}) {
+		return nil, []string{"Unable to find current space, please view cf target"}
+	}
+	space, err := cliConnection.GetSpace(currSpace.SpaceFields.Name)
+	if err != nil {
+		return nil, []string{"Unable to read security groups for space " + currSpace.SpaceFields.Name + ": ", err.Error()}
+	}
+
+	var rules []asgRule
+	for _, group := range space.SecurityGroups {
+		rules = append(rules, newASGRules(group.Name, group.Rules)...)
+	}
+
+	output, err := cliConnection.CliCommandWithoutTerminalOutput("curl", "/v2/config/running_security_groups")
+	var running runningSecurityGroups
+	if err == nil {
+		err = json.Unmarshal([]byte(strings.Join(output, "\n")), &running)
+	}
+	// cf curl succeeds with the cloud controller's error, such as a 403 for non-admins, which has no resources
+	if err != nil || running.Resources == nil {
+		fmt.Fprintln(os.Stderr, "Unable to read running security groups, only space security groups will be evaluated")
+	}
+	for _, resource := range running.Resources {
+		rules = append(rules, newASGRules(resource.Entity.Name, resource.Entity.Rules)...)
+	}
+	return rules, nil
+}
+
+func newASGRules(group string, rules []map[string]interface{}) []asgRule {
+	var asgRules []asgRule
+	for i, rule := range rules {
+		protocol, _ := rule["protocol"].(string)
+		destination, _ := rule["destination"].(string)
+		ports, _ := rule["ports"].(string)
+		asgRules = append(asgRules, asgRule{group, i + 1, strings.ToLower(protocol), destination, ports})
+	}
+	return asgRules
+}
+
+// evaluateASG checks whether any rule permits tcp traffic from the app to the request, or to its proxy when
+// it has one, since that is where willitconnect actually connects
+func evaluateASG(rules []asgRule, request *wicRequest) *asgReport {
+	host := request.host
+	portNumber, _ := strconv.Atoi(request.port)
+	if request.proxy != nil {
+		host, portNumber = request.proxy.host, request.proxy.port
+	}
+
+	ips, err := resolveHost(host)
+	if err != nil {
+		return &asgReport{Verdicts: []asgVerdict{{Port: portNumber, Error: "Unable to resolve " + host + ": " + err.Error()}}}
+	}
+
+	report := asgReport{Permitted: true}
+	for _, ip := range ips {
+		verdict := asgVerdict{IP: ip.String(), Port: portNumber}
+		for _, rule := range rules {
+			if rule.permits(ip, portNumber) {
+				verdict.Permitted = true
+				verdict.Rule = rule.String()
+				break
+			}
+		}
+		report.Permitted = report.Permitted && verdict.Permitted
+		report.Verdicts = append(report.Verdicts, verdict)
+	}
+	return &report
+}
+
+// resolveHost returns the ips of a host, which may be a url
+func resolveHost(host string) ([]net.IP, error) {
+	if strings.Contains(host, "://") {
+		parsed, err := url.Parse(host)
+		if err != nil {
+			return nil, err
+		}
+		host = parsed.Hostname()
+	}
+	host = strings.Trim(host, "[]")
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return net.LookupIP(host)
+}
+
+func (r asgRule) permits(ip net.IP, port int) bool {
+	switch r.protocol {
+	case "all":
+		return destinationContains(r.destination, ip)
+	case "tcp":
+		return destinationContains(r.destination, ip) && portsContain(r.ports, port)
+	}
+	return false
+}
+
+// destinationContains matches a single ip, a cidr, an ip range or a comma separated list of them
+func destinationContains(destination string, ip net.IP) bool {
+	for _, entry := range strings.Split(destination, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+		case strings.Contains(entry, "-"):
+			bounds := strings.SplitN(entry, "-", 2)
+			low, high := net.ParseIP(strings.TrimSpace(bounds[0])), net.ParseIP(strings.TrimSpace(bounds[1]))
+			if low != nil && high != nil && bytes.Compare(ip.To16(), low.To16()) >= 0 && bytes.Compare(ip.To16(), high.To16()) <= 0 {
+				return true
+			}
+		default:
+			if other := net.ParseIP(entry); other != nil && other.Equal(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// portsContain matches a single port, a port range or a comma separated list of them
+func portsContain(ports string, port int) bool {
+	for _, entry := range strings.Split(ports, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(entry), "-", 2)
+		low, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			continue
+		}
+		high := low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+				continue
+			}
+		}
+		if port >= low && port <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// formatASG describes an asg report, and whether it points at an asg or an external firewall
+func formatASG(result *wicResult) []string {
+	var lines []string
+	for _, verdict := range result.ASG.Verdicts {
+		switch {
+		case verdict.Error != "":
+			lines = append(lines, "ASG: "+verdict.Error)
+		case verdict.Permitted:
+			lines = append(lines, fmt.Sprintf("ASG: %s:%d is permitted by %s", verdict.IP, verdict.Port, verdict.Rule))
+		default:
+			lines = append(lines, fmt.Sprintf("ASG: %s:%d is not permitted by any ASG rule", verdict.IP, verdict.Port))
+		}
+	}
+
+	if len(result.ASG.Verdicts) == 1 && result.ASG.Verdicts[0].Error != "" {
+		return lines
+	}
+	switch {
+	case !result.ASG.Permitted:
+		lines = append(lines, "An ASG is likely blocking this connection")
+	case result.err == nil && !result.CanConnect:
+		lines = append(lines, "ASGs permit this connection, it is likely blocked by an external firewall")
+	}
+	return lines
+}
+
+// asgSuggestion is a rule in the format accepted by cf create-security-group
+type asgSuggestion struct {
+	Protocol    string `json:"protocol"`
+	Destination string `json:"destination"`
+	Ports       string `json:"ports"`
+	Description string `json:"description,omitempty"`
+}
+
+// suggestASGRules builds the minimal rules needed to permit every ip no existing rule permits,
+// with one rule per ip listing all of its ports. Targets that connected need no rule. It also returns why
+// no rule could be suggested for failing targets that couldn't be resolved.
+func suggestASGRules(results []wicResult) ([]asgSuggestion, []string) {
+	var suggestions []asgSuggestion
+	var unresolved []string
+	index := map[string]int{}
+	ports := map[string][]int{}
+	for _, result := range results {
+		if result.ASG == nil || (result.err == nil && result.CanConnect) {
+			continue
+		}
+		description := "willitconnect " + result.Target
+		if result.Proxy != "" {
+			// the rule opens the proxy, which is where willitconnect connects
+			description = "proxy " + result.Proxy + " for willitconnect " + result.Target
+		}
+		for _, verdict := range result.ASG.Verdicts {
+			if verdict.Error != "" {
+				unresolved = append(unresolved, "No rule suggested for "+result.Target+", "+verdict.Error)
+				continue
+			}
+			if verdict.Permitted {
+				continue
+			}
+			if _, ok := index[verdict.IP]; !ok {
+				index[verdict.IP] = len(suggestions)
+				suggestions = append(suggestions, asgSuggestion{Protocol: "tcp", Destination: verdict.IP,
+					Description: description})
+			}
+			if !containsPort(ports[verdict.IP], verdict.Port) {
+				ports[verdict.IP] = append(ports[verdict.IP], verdict.Port)
+			}
+		}
+	}
+
+	for i := range suggestions {
+		var portList []string
+		for _, port := range ports[suggestions[i].Destination] {
+			portList = append(portList, strconv.Itoa(port))
+		}
+		suggestions[i].Ports = strings.Join(portList, ",")
+	}
+	return suggestions, unresolved
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestASG prints, or writes to options.asgFile, the security group rules needed to open the failing targets
+func (c *WillItConnect) suggestASG(results []wicResult, options *wicOptions) []string {
+	suggestions, unresolved := suggestASGRules(results)
+	for _, line := range unresolved {
+		fmt.Println(line)
+	}
+	switch {
+	case len(suggestions) == 0 && len(unresolved) == 0:
+		fmt.Println("All targets are permitted by ASGs, there are no rules to suggest")
+		return nil
+	case len(suggestions) == 0:
+		fmt.Println("There are no rules to suggest for the targets that could be resolved")
+		return nil
+	}
+
+	document, err := json.MarshalIndent(suggestions, "", "  ")
+	if err != nil {
+		return []string{"Unable to build ASG rules: ", err.Error()}
+	}
+	document = append(document, '\n')
+
+	if options.asgFile == "" {
+		fmt.Println("Suggested ASG rules:")
+		fmt.Print(string(document))
+		return nil
+	}
+
+	if err := ioutil.WriteFile(options.asgFile, document, 0644); err != nil {
+		return []string{"Unable to write ASG rules: ", err.Error()}
+	}
+	fmt.Println("ASG rules written to " + options.asgFile + ", create them with: cf create-security-group <name> " + options.asgFile)
+	return nil
+}
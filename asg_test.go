@@ -0,0 +1,195 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+const runningGroups string = `{"resources": [{"entity": {"name": "public_networks", "rules": [
+  {"protocol": "all", "destination": "0.0.0.0-9.255.255.255"},
+  {"protocol": "udp", "destination": "11.0.0.0-169.253.255.255", "ports": "53"}]}}]}`
+
+var _ = Describe("ASG evaluation", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+		fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "dev"}}, nil)
+		fakeCliConnection.GetSpaceReturns(plugin_models.GetSpace_Model{SecurityGroups: []plugin_models.GetSpace_SecurityGroup{
+			{Name: "databases", Rules: []map[string]interface{}{
+				{"protocol": "tcp", "destination": "10.10.0.1,10.10.0.2", "ports": "3306"},
+				{"protocol": "tcp", "destination": "10.0.0.0/24", "ports": "5432,5433,6000-6010"},
+			}},
+		}}, nil)
+		fakeCliConnection.CliCommandWithoutTerminalOutputReturns([]string{runningGroups}, nil)
+	})
+
+	check := func(host string, port string, response string, flags ...string) []string {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).Reply(200).JSON(response)
+		if len(flags) == 0 {
+			flags = []string{"-asg"}
+		}
+		return CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, append([]string{"willitconnect", "-host=" + host, "-port=" + port}, flags...))
+		})
+	}
+
+	It("reports the rule permitting the target", func() {
+		output := check("10.0.0.5", "6005", goodResponse)
+		Expect(fakeCliConnection.GetSpaceArgsForCall(0)).To(Equal("dev"))
+		Expect(fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(0)).To(Equal([]string{"curl", "/v2/config/running_security_groups"}))
+		Expect(output).To(ContainSubstrings([]string{"ASG: 10.0.0.5:6005 is permitted by databases rule 2 (tcp 10.0.0.0/24 5432,5433,6000-6010)"}))
+	})
+
+	It("evaluates comma separated destinations", func() {
+		output := check("10.10.0.2", "3306", goodResponse)
+		Expect(output).To(ContainSubstrings([]string{"ASG: 10.10.0.2:3306 is permitted by databases rule 1"}))
+	})
+
+	It("evaluates the running security groups", func() {
+		output := check("8.8.8.8", "443", badResponse)
+		Expect(output).To(ContainSubstrings([]string{"I am unable to connect"}))
+		Expect(output).To(ContainSubstrings([]string{"ASG: 8.8.8.8:443 is permitted by public_networks rule 1 (all 0.0.0.0-9.255.255.255)"}))
+		Expect(output).To(ContainSubstrings([]string{"ASGs permit this connection, it is likely blocked by an external firewall"}))
+	})
+
+	It("flags targets no rule permits", func() {
+		output := check("10.0.0.5", "80", badResponse)
+		Expect(output).To(ContainSubstrings([]string{"ASG: 10.0.0.5:80 is not permitted by any ASG rule"}))
+		Expect(output).To(ContainSubstrings([]string{"An ASG is likely blocking this connection"}))
+	})
+
+	It("only matches tcp rules", func() {
+		output := check("12.0.0.1", "53", badResponse)
+		Expect(output).To(ContainSubstrings([]string{"ASG: 12.0.0.1:53 is not permitted by any ASG rule"}))
+	})
+
+	It("evaluates the proxy rather than the target", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).Reply(200).JSON(goodResponse)
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=80", "-proxyHost=10.0.0.9", "-proxyPort=6001", "-asg"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"ASG: 10.0.0.9:6001 is permitted by databases rule 2"}))
+	})
+
+	It("includes the evaluation in structured output", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).Reply(200).JSON(goodResponse)
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=5432", "-asg", "-output=json"})
+		})
+		Expect(output).To(ContainSubstrings([]string{`"asg": {`}, []string{`"permitted": true`}, []string{`"ip": "10.0.0.5"`}))
+	})
+
+	It("warns when the running security groups can't be read", func() {
+		fakeCliConnection.CliCommandWithoutTerminalOutputReturns([]string{`{"description": "You are not authorized to perform the requested action", "error_code": "CF-NotAuthorized", "code": 10003}`}, nil)
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).Reply(200).JSON(goodResponse)
+		output := CaptureOutput(func() {
+			stderr := os.Stderr
+			os.Stderr = os.Stdout
+			defer func() { os.Stderr = stderr }()
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=6005", "-asg", "-route=willitconnect.cfapps.io"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Unable to read running security groups, only space security groups will be evaluated"}))
+		Expect(output).To(ContainSubstrings([]string{"ASG: 10.0.0.5:6005 is permitted by databases rule 2"}))
+	})
+
+	It("reports a missing space", func() {
+		fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{}, nil)
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=5432", "-asg"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Unable to find current space, please view cf target"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(3))
+	})
+
+	Context("suggesting rules", func() {
+		It("prints the rules needed to open targets no rule permits", func() {
+			output := check("10.0.0.5", "80", badResponse, "-suggest-asg")
+			Expect(output).To(ContainSubstrings([]string{"Suggested ASG rules:"}))
+			Expect(output).To(ContainSubstrings([]string{`"protocol": "tcp"`}, []string{`"destination": "10.0.0.5"`}, []string{`"ports": "80"`}))
+		})
+
+		It("writes one rule per ip to the asg file", func() {
+			file, err := ioutil.TempFile("", "wic-asg")
+			Expect(err).NotTo(HaveOccurred())
+			file.Close()
+			defer os.Remove(file.Name())
+			targets, err := ioutil.TempFile("", "wic-targets")
+			Expect(err).NotTo(HaveOccurred())
+			targets.WriteString("10.0.0.5:80\n10.0.0.5:443\n10.0.0.5:5432\n192.168.0.1:80\n")
+			targets.Close()
+			defer os.Remove(targets.Name())
+
+			defer gock.Off()
+			gock.New(wicURL).Post(wicPath).Times(4).Reply(200).JSON(badResponse)
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-file=" + targets.Name(), "-suggest-asg", "-asgFile=" + file.Name()})
+			})
+			Expect(output).To(ContainSubstrings([]string{"ASG rules written to " + file.Name(), "cf create-security-group"}))
+
+			contents, err := ioutil.ReadFile(file.Name())
+			Expect(err).NotTo(HaveOccurred())
+			var rules []map[string]string
+			Expect(json.Unmarshal(contents, &rules)).To(Succeed())
+			Expect(rules).To(HaveLen(2))
+			Expect(rules[0]).To(HaveKeyWithValue("destination", "10.0.0.5"))
+			Expect(rules[0]).To(HaveKeyWithValue("ports", "80,443"))
+			Expect(rules[1]).To(HaveKeyWithValue("destination", "192.168.0.1"))
+			Expect(rules[1]).To(HaveKeyWithValue("ports", "80"))
+		})
+
+		It("has nothing to suggest when every target is permitted", func() {
+			defer gock.Off()
+			gock.New(wicURL).Post(wicPath).Reply(200).JSON(goodResponse)
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=5432", "-suggest-asg"})
+			})
+			Expect(output).To(ContainSubstrings([]string{"All targets are permitted by ASGs, there are no rules to suggest"}))
+		})
+
+		It("names the proxy when the rule opens it", func() {
+			output := check("10.0.0.5", "80", badResponse, "-suggest-asg", "-proxyHost=192.168.0.9", "-proxyPort=3128")
+			Expect(output).To(ContainSubstrings([]string{`"destination": "192.168.0.9"`}, []string{`"ports": "3128"`},
+				[]string{`"description": "proxy 192.168.0.9:3128 for willitconnect 10.0.0.5`}))
+		})
+
+		It("reports targets that couldn't be resolved rather than that every target is permitted", func() {
+			output := check("does-not-exist.invalid", "80", badResponse, "-suggest-asg")
+			Expect(output).To(ContainSubstrings([]string{"No rule suggested for does-not-exist.invalid", "Unable to resolve does-not-exist.invalid"}))
+			Expect(output).To(ContainSubstrings([]string{"There are no rules to suggest for the targets that could be resolved"}))
+			Expect(output).NotTo(ContainSubstrings([]string{"All targets are permitted"}))
+		})
+
+		It("doesn't suggest rules for targets that connected", func() {
+			output := check("192.168.0.1", "80", goodResponse, "-suggest-asg")
+			Expect(output).To(ContainSubstrings([]string{"ASG: 192.168.0.1:80 is not permitted by any ASG rule"}))
+			Expect(output).To(ContainSubstrings([]string{"there are no rules to suggest"}))
+			Expect(output).NotTo(ContainSubstrings([]string{"Suggested ASG rules:"}))
+		})
+
+		It("requires a file for structured output", func() {
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.5", "-port=80", "-suggest-asg", "-output=json"})
+			})
+			Expect(output).To(ContainSubstrings([]string{"-suggest-asg with -output=json requires -asgFile or -outputFile"}))
+		})
+	})
+})
@@ -0,0 +1,192 @@
+package main_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Authentication", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+	var files []string
+
+	tempFile := func(contents []byte) string {
+		file, err := ioutil.TempFile("", "wic-auth")
+		Expect(err).NotTo(HaveOccurred())
+		file.Write(contents)
+		file.Close()
+		files = append(files, file.Name())
+		return file.Name()
+	}
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+		files = nil
+	})
+
+	AfterEach(func() {
+		for _, file := range files {
+			os.Remove(file)
+		}
+		os.Unsetenv("WILLITCONNECT_CONFIG")
+		os.Unsetenv("WILLITCONNECT_USERNAME")
+		os.Unsetenv("WILLITCONNECT_PASSWORD")
+	})
+
+	run := func(args ...string) []string {
+		return CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, append([]string{"willitconnect", "-host=foo.com", "-port=80"}, args...))
+		})
+	}
+
+	It("sends the cf access token with -cf-token", func() {
+		fakeCliConnection.AccessTokenReturns("bearer abc", nil)
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).MatchHeader("Authorization", "^Bearer abc$").JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+		output := run("-cf-token", "-route=willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"Sending your cf access token to https://willitconnect.cfapps.io"}))
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("requires -route with -cf-token", func() {
+		output := run("-cf-token")
+		Expect(output).To(ContainSubstrings([]string{"-cf-token requires -route"}))
+		Expect(fakeCliConnection.AccessTokenCallCount()).To(Equal(0))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("refuses to send a cf token over http", func() {
+		fakeCliConnection.AccessTokenReturns("bearer abc", nil)
+		output := run("-cf-token", "-route=http://willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"Refusing to send credentials to http://willitconnect.cfapps.io/v2/willitconnect, use an https -route"}))
+		Expect(fakeCliConnection.AccessTokenCallCount()).To(Equal(0))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("refuses to send basic auth over http", func() {
+		output := run("-username=user", "-password=secret", "-route=http://willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"Refusing to send credentials to http://willitconnect.cfapps.io/v2/willitconnect"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("asks for a cf login when there is no access token", func() {
+		fakeCliConnection.AccessTokenReturns("", errors.New("not logged in"))
+
+		output := run("-cf-token", "-route=willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"Unable to get an access token, please cf login"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(3))
+	})
+
+	It("sends basic auth from the environment", func() {
+		os.Setenv("WILLITCONNECT_USERNAME", "user")
+		os.Setenv("WILLITCONNECT_PASSWORD", "secret")
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).MatchHeader("Authorization", "^Basic dXNlcjpzZWNyZXQ=$").JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+		output := run("-route=willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("doesn't send basic auth from the config to a discovered route", func() {
+		os.Setenv("WILLITCONNECT_CONFIG", tempFile([]byte("username: user\npassword: secret\n")))
+		fakeCliConnection.GetAppsReturns([]plugin_models.GetAppsModel{{Name: "not-my-willitconnect", State: "started",
+			Routes: []plugin_models.GetAppsRouteSummary{{Host: "elsewhere", Domain: plugin_models.GetAppsDomainFields{Name: "cfapps.io"}}}}}, nil)
+		defer gock.Off()
+		gock.New("https://elsewhere.cfapps.io").Post(wicPath).Reply(200).JSON(goodResponse)
+
+		output := run()
+		Expect(output).To(ContainSubstrings([]string{"Basic auth requires -route, so your password is only sent to a willitconnect you chose"}))
+		Expect(gock.IsDone()).To(BeFalse())
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("reads basic auth from the config, with flags taking precedence", func() {
+		os.Setenv("WILLITCONNECT_CONFIG", tempFile([]byte("username: user\npassword: wrong\n")))
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).MatchHeader("Authorization", "^Basic dXNlcjpzZWNyZXQ=$").JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+		output := run("-password=secret", "-route=willitconnect.cfapps.io")
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		Expect(gock.IsDone()).To(BeTrue())
+	})
+
+	It("rejects an invalid config", func() {
+		os.Setenv("WILLITCONNECT_CONFIG", tempFile([]byte("username: [")))
+
+		output := run()
+		Expect(output).To(ContainSubstrings([]string{"Invalid config "}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("rejects -cf-token combined with basic auth", func() {
+		output := run("-cf-token", "-username=user")
+		Expect(output).To(ContainSubstrings([]string{"-cf-token cannot be combined with -username"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("presents a client certificate", func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		template := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "wic-client"},
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).NotTo(HaveOccurred())
+		keyDer, err := x509.MarshalECPrivateKey(key)
+		Expect(err).NotTo(HaveOccurred())
+		clientCert, err := x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCert)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(goodResponse))
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		server.StartTLS()
+		defer server.Close()
+
+		caCert := tempFile(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+		certFile := tempFile(append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...))
+
+		output := run("-route="+server.URL, "-retries=0", "-ca-cert="+caCert)
+		Expect(output).NotTo(ContainSubstrings([]string{"I am able to connect"}))
+
+		output = run("-route="+server.URL, "-retries=0", "-ca-cert="+caCert, "-client-cert="+certFile)
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("rejects a client certificate that can't be loaded", func() {
+		output := run("-client-cert=" + tempFile([]byte("not a certificate")))
+		Expect(output).To(ContainSubstrings([]string{"Unable to load client certificate: "}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+})
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gambtho/cf_will_it_connect_plugin/wicclient"
+)
+
+type wicTarget struct {
+	host  string
+	port  int
+	proxy *targetProxy
+}
+
+// readTargetFile reads the targets for a batch run from path, or from stdin when path is -
+func readTargetFile(path string) ([]wicTarget, []string) {
+	if path == "-" {
+		return readTargets(os.Stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, []string{"Unable to read target file: ", err.Error()}
+	}
+	defer file.Close()
+	return readTargets(file)
+}
+
+// readTargets parses one target per line, blank lines and lines starting with # are skipped.
+// A line is either a url, host:port, or host and port columns optionally followed by
+// proxy host and proxy port columns, separated by whitespace or commas.
+func readTargets(reader io.Reader) ([]wicTarget, []string) {
+	var targets []wicTarget
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target, err := parseTarget(line)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("Invalid target on line %d: ", lineNumber), err.Error()}
+		}
+		targets = append(targets, *target)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, []string{"Unable to read target file: ", err.Error()}
+	}
+	if len(targets) == 0 {
+		return nil, []string{"No targets found in target file"}
+	}
+	return targets, nil
+}
+
+func parseTarget(line string) (*wicTarget, error) {
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) > 0 && strings.Contains(fields[0], "://") {
+		// a url may have commas in its query or hosts, so only whitespace separates it from the other fields
+		fields = strings.Fields(line)
+	}
+
+	target := wicTarget{port: -1}
+	var err error
+	switch len(fields) {
+	case 1:
+		if target.host, target.port, err = parseAddress(fields[0], -1); err == nil && target.port == -1 {
+			return nil, fmt.Errorf("%q is not a url or host:port", line)
+		}
+	case 2:
+		if target.port, err = parsePort(fields[1]); err == nil {
+			target.host, target.port, err = parseAddress(fields[0], target.port)
+		}
+	case 4:
+		target.proxy = &targetProxy{scheme: "http", host: strings.Trim(fields[2], "[]")}
+		if target.port, err = parsePort(fields[1]); err == nil {
+			target.proxy.port, err = parsePort(fields[3])
+		}
+		if err == nil {
+			target.host, target.port, err = parseAddress(fields[0], target.port)
+		}
+	default:
+		return nil, fmt.Errorf("%q should be a url, host:port, host port or host port proxyHost proxyPort", line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// parseAddress splits a url, connection uri, host:port or host into the host and port to check, port is used
+// when the address doesn't name one and is -1 when unknown. Credentials are removed. Http and https urls keep
+// their scheme, path and query so willitconnect makes an http connection, while connection uris such as
+// postgres:// or jdbc:mysql:// are checked as a socket to their host. Urls default to their scheme's port,
+// and IPv6 hosts are returned without brackets unless they are part of a url.
+func parseAddress(address string, port int) (string, int, error) {
+	var host, explicitPort string
+	scheme, hostPorts := splitServiceURI(address)
+	switch {
+	case scheme == "http" || scheme == "https":
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return "", -1, fmt.Errorf("%q is not a valid url", address)
+		}
+		if parsed.Hostname() == "" {
+			return "", -1, fmt.Errorf("%q has no host", address)
+		}
+		explicitPort = parsed.Port()
+		parsed.User = nil
+		parsed.Host = parsed.Hostname()
+		if strings.Contains(parsed.Host, ":") {
+			parsed.Host = "[" + parsed.Host + "]"
+		}
+		host = parsed.String()
+	case scheme != "":
+		if _, known := schemePorts[scheme]; !known {
+			return "", -1, fmt.Errorf("%q has an unsupported scheme, use one of %s", address, strings.Join(supportedSchemes(), ", "))
+		}
+		if len(hostPorts) != 1 {
+			return "", -1, fmt.Errorf("%q lists several hosts, check each one separately", address)
+		}
+		host, explicitPort = splitHostPort(hostPorts[0])
+		if host == "" {
+			return "", -1, fmt.Errorf("%q has no host", address)
+		}
+	default:
+		host, explicitPort = splitHostPort(address)
+	}
+
+	if explicitPort != "" {
+		parsed, err := parsePort(explicitPort)
+		if err != nil {
+			return "", -1, err
+		}
+		if port != -1 && port != parsed {
+			return "", -1, fmt.Errorf("%q names port %d, which conflicts with port %d", address, parsed, port)
+		}
+		port = parsed
+	} else if port == -1 && scheme != "" {
+		port = schemePorts[scheme]
+	}
+	return host, port, nil
+}
+
+// splitHostPort splits host:port, or returns a host without a port, removing any IPv6 brackets
+func splitHostPort(address string) (string, string) {
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		return host, port
+	}
+	return strings.Trim(address, "[]"), ""
+}
+
+func supportedSchemes() []string {
+	var schemes []string
+	for scheme := range schemePorts {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return -1, fmt.Errorf("%q is not a valid port", value)
+	}
+	return port, nil
+}
+
+// maxPorts caps how many ports a single -port list or range may expand to
+const maxPorts = 1024
+
+// parsePorts parses a comma separated list of ports and port ranges such as 5671,5672 or 9092-9094,
+// returning no ports for an empty value
+func parsePorts(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		first, last := field, field
+		if dash := strings.Index(field, "-"); dash != -1 {
+			first, last = field[:dash], field[dash+1:]
+		}
+		start, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parsePort(last)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("%q is not a valid port range", field)
+		}
+		if len(ports)+end-start+1 > maxPorts {
+			return nil, fmt.Errorf("%q is more than %d ports", value, maxPorts)
+		}
+		for port := start; port <= end; port++ {
+			if !containsPort(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+type wicSummary struct {
+	Total         int `json:"total" yaml:"total"`
+	CanConnect    int `json:"canConnect" yaml:"canConnect"`
+	CannotConnect int `json:"cannotConnect" yaml:"cannotConnect"`
+	Errors        int `json:"errors" yaml:"errors"`
+}
+
+func (s *wicSummary) add(response *wicclient.CheckResult, err []string) {
+	s.Total++
+	switch {
+	case err != nil:
+		s.Errors++
+	case response.CanConnect:
+		s.CanConnect++
+	default:
+		s.CannotConnect++
+	}
+}
+
+func (s *wicSummary) String() string {
+	return fmt.Sprintf("Checked %d targets: %d able to connect, %d unable to connect, %d errors",
+		s.Total, s.CanConnect, s.CannotConnect, s.Errors)
+}
+
+// exitCode reports the most severe outcome, a failure to check any target outranks an unreachable target
+func (s *wicSummary) exitCode() int {
+	switch {
+	case s.Errors > 0:
+		return exitWicError
+	case s.CannotConnect > 0:
+		return exitCannotConnect
+	}
+	return exitSuccess
+}
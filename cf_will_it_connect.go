@@ -1,24 +1,48 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cloudfoundry/cli/plugin"
 	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/gambtho/cf_will_it_connect_plugin/wicclient"
 )
 
-const wicPath string = "/v2/willitconnect"
+const wicPath string = wicclient.Path
 const wicRoute string = "willitconnect"
-const usage string = "cf willitconnect -host=<host> -port=<port> [proxyHost=<proxyHost>] proxyPort=<proxyPort>] [-route=<route>] "
+
+// maxParallel caps -parallel, maxRate caps -rate, and defaultRate is how many calls a second are made to
+// willitconnect unless -rate says otherwise
+const maxParallel = 32
+const maxRate = 1000
+const defaultRate = 20
+
+// defaultTimeout and defaultRetries bound each call to willitconnect unless -timeout or -retries say otherwise
+const defaultTimeout = 30 * time.Second
+const defaultRetries = 2
+const usage string = "cf willitconnect -host=<host> -port=<port[,port|-port]> [-proxyHost=<proxyHost> -proxyPort=<proxyPort> | -proxy=<url>] [-no-proxy=<hosts>] [-route=<route>] [-file=<path>] [-output=<format>] [-outputFile=<path>] [-app=<app>] [-asg] [-suggest-asg [-asgFile=<path>]] [-searchOrg] [-ensure [-appPath=<path>]] [-ephemeral] [-domain=<domain>] [-parallel=<n>] [-rate=<n>] [-timeout=<duration>] [-retries=<n>] [-ca-cert=<path>] [-cf-token | -username=<username> -password=<password>] [-client-cert=<path> [-client-key=<path>]] [-client-proxy=<url|direct>] [-find-proxy [-proxies=<urls>] [-proxies-file=<path>]] [-as-app=<app>] "
+
+// Exit codes returned by the plugin, so shells and CI can branch on the outcome of a check
+const (
+	exitSuccess       = 0
+	exitCannotConnect = 1
+	exitUsage         = 2
+	exitCFError       = 3
+	exitWicError      = 4
+)
 
 //WillItConnect ...
-type WillItConnect struct{}
+type WillItConnect struct {
+	exitCode int
+}
 
 //GetMetadata ...
 func (c *WillItConnect) GetMetadata() plugin.PluginMetadata {
@@ -36,8 +60,41 @@ func (c *WillItConnect) GetMetadata() plugin.PluginMetadata {
 				HelpText: "Validates connectivity between CF and a target \n",
 				UsageDetails: plugin.Usage{
 					Usage: "willitconnect\n   Usage: cf willitconnect -host=<host> -port=<port>\n" +
-						"cf willitconnect <url>\n" +
-						"cf willitconnect -host=<host -port=<port> -proxyHost=<proxyHost -proxyPort=<proxyPort -route=<route>\n",
+						"cf willitconnect <url|host:port>\n" +
+						"cf willitconnect -host=<host> -port=<port,port|port-port>\n" +
+						"cf willitconnect -host=<cidr> -port=<port>\n" +
+						"cf willitconnect -file=<path|-> -parallel=<n> [-rate=<calls per second>]\n" +
+						"cf willitconnect -host=<host> -port=<port> -timeout=<duration> -retries=<n>\n" +
+						"cf willitconnect -host=<host> -port=<port> -ca-cert=<CA bundle>\n" +
+						"cf willitconnect -host=<host> -port=<port> -cf-token\n" +
+						"cf willitconnect -host=<host> -port=<port> -username=<username> -password=<password>\n" +
+						"cf willitconnect -host=<host> -port=<port> -client-cert=<path> [-client-key=<path>]\n" +
+						"cf willitconnect -host=<host> -port=<port> -client-proxy=<url|direct>\n" +
+						"cf willitconnect -host=<host -port=<port> -proxyHost=<proxyHost -proxyPort=<proxyPort -route=<route>\n" +
+						"cf willitconnect -host=<host> -port=<port> -proxy=<http|https|socks5 url> [-no-proxy=<hosts>]\n" +
+						"cf willitconnect -host=<host> -port=<port> -find-proxy -proxies=<url,url...>|-proxies-file=<path>\n" +
+						"cf willitconnect -host=<host> -port=<port> -as-app=<app>\n" +
+						"cf willitconnect -file=<path|->\n" +
+						"cf willitconnect -host=<host> -port=<port> -output=<text|json|yaml|csv|junit> [-outputFile=<path>]\n" +
+						"cf willitconnect -app=<app>\n" +
+						"cf willitconnect -host=<host> -port=<port> -asg\n" +
+						"cf willitconnect -host=<host> -port=<port> -suggest-asg [-asgFile=<path>]\n" +
+						"cf willitconnect -host=<host> -port=<port> -ensure [-appPath=<willitconnect jar>] [-domain=<domain>]\n" +
+						"cf willitconnect -host=<host> -port=<port> -ephemeral [-appPath=<willitconnect jar>] [-domain=<domain>]\n",
+				},
+			},
+			{
+				Name:     "wic-deploy",
+				HelpText: "Deploys willitconnect to the current space \n",
+				UsageDetails: plugin.Usage{
+					Usage: "wic-deploy\n   Usage: cf wic-deploy -path=<willitconnect jar> [-name=<app name>] [-domain=<domain>] [-ca-cert=<CA bundle>]\n",
+				},
+			},
+			{
+				Name:     "wic-teardown",
+				HelpText: "Deletes willitconnect and its routes from the current space \n",
+				UsageDetails: plugin.Usage{
+					Usage: "wic-teardown\n   Usage: cf wic-teardown [-name=<app name>]\n",
 				},
 			},
 		},
@@ -45,60 +102,233 @@ func (c *WillItConnect) GetMetadata() plugin.PluginMetadata {
 }
 
 func main() {
-	plugin.Start(new(WillItConnect))
+	willItConnect := new(WillItConnect)
+	plugin.Start(willItConnect)
+	os.Exit(willItConnect.ExitCode())
+}
+
+//ExitCode returns the exit code of the last Run
+func (c *WillItConnect) ExitCode() int {
+	return c.exitCode
 }
 
 //Run ...
 func (c *WillItConnect) Run(cliConnection plugin.CliConnection, args []string) {
+	c.exitCode = exitSuccess
 
-	baseURL, cfErr := c.getBaseURL(cliConnection)
+	switch args[0] {
+	case "wic-deploy":
+		c.runDeploy(cliConnection, args)
+		return
+	case "wic-teardown":
+		c.runTeardown(cliConnection, args)
+		return
+	}
+
+	org, cfErr := c.getOrg(cliConnection)
 
 	if cfErr != nil {
 		fmt.Println(cfErr)
+		c.exitCode = exitCFError
 		return
 	}
 
-	request, argsErr := c.parseArgs(args, baseURL)
+	options, argsErr := c.parseArgs(args)
 
 	if argsErr != nil {
 		fmt.Println(argsErr)
+		c.exitCode = exitUsage
 		return
 	}
 
-	fmt.Println([]string{"Host: ", request.host, " - Port: ", request.port, " - WillItConnect: ", request.url})
-	if request.hasProxy {
-		fmt.Println([]string{"Proxy: " + request.proxyHost + ":" + request.proxyPort})
+	transport, tlsErr := newTransport(cliConnection, options.tls)
+	if tlsErr != nil {
+		fmt.Println(tlsErr)
+		c.exitCode = exitUsage
+		return
+	}
+	options.transport = transport
+
+	if options.ephemeral {
+		cleanup, ephemeralErr := c.deployEphemeral(cliConnection, org, options)
+		defer cleanup()
+		if ephemeralErr != nil {
+			fmt.Println(ephemeralErr)
+			c.exitCode = exitCFError
+			return
+		}
+	} else if options.wicURL == "" {
+		if options.username != "" {
+			// discovery may pick any app named like willitconnect, so basic auth only goes to a route the user names
+			fmt.Println([]string{"Basic auth requires -route, so your password is only sent to a willitconnect you chose"})
+			c.exitCode = exitUsage
+			return
+		}
+		wicURL, reason := c.discoverWicURL(cliConnection, org, options)
+		options.wicURL = wicURL + wicPath
+		options.notify("Using " + wicURL + ", " + reason)
 	}
 
-	response, conErr := c.connect(request)
+	if options.ensure {
+		if ensureErr := c.ensureWic(cliConnection, org, options); ensureErr != nil {
+			fmt.Println(ensureErr)
+			c.exitCode = exitCFError
+			return
+		}
+	}
 
-	if conErr != nil {
-		fmt.Println(conErr)
+	if route := describeClientProxy(options.tls.proxy, options.wicURL); route != "" {
+		options.notify(route)
+	}
+
+	if (options.cfToken || options.username != "") && !strings.HasPrefix(options.wicURL, "https://") {
+		fmt.Println([]string{"Refusing to send credentials to " + options.wicURL + ", use an https -route"})
+		c.exitCode = exitUsage
 		return
 	}
-	fmt.Println(response)
+	if options.cfToken {
+		token, tokenErr := cliConnection.AccessToken()
+		if tokenErr != nil || token == "" {
+			fmt.Println([]string{"Unable to get an access token, please cf login"})
+			c.exitCode = exitCFError
+			return
+		}
+		options.token = token
+		options.notify("Sending your cf access token to " + strings.TrimSuffix(options.wicURL, wicPath))
+	}
+
+	if options.app != "" {
+		requests, appErr := c.appRequests(cliConnection, options)
+		if appErr != nil {
+			fmt.Println(appErr)
+			c.exitCode = exitCFError
+			return
+		}
+		options.requests = requests
+	}
+	if options.asApp != "" {
+		if appProxyErr := c.applyAppProxy(cliConnection, options); appProxyErr != nil {
+			fmt.Println(appProxyErr)
+			c.exitCode = exitCFError
+			return
+		}
+	}
+	for _, request := range options.requests {
+		request.url = options.wicURL
+	}
+
+	var rules []asgRule
+	if options.asg {
+		var asgErr []string
+		if rules, asgErr = c.spaceASGRules(cliConnection); asgErr != nil {
+			fmt.Println(asgErr)
+			c.exitCode = exitCFError
+			return
+		}
+	}
+
+	out := os.Stdout
+	if options.outputFile != "" {
+		file, fileErr := os.Create(options.outputFile)
+		if fileErr != nil {
+			fmt.Println([]string{"Unable to create output file: ", fileErr.Error()})
+			c.exitCode = exitUsage
+			return
+		}
+		defer file.Close()
+		out = file
+	}
+
+	reporter := newReporter(options, out)
+
+	var summary wicSummary
+	var results []wicResult
+	outcomes := c.connectAll(options)
+	for i, request := range options.requests {
+		reporter.checking(request)
+		outcome := <-outcomes[i]
+		summary.add(outcome.response, outcome.err)
+		result := newResult(request, outcome.response, outcome.attempts, outcome.err)
+		if options.asg {
+			result.ASG = evaluateASG(rules, request)
+		}
+		reporter.checked(result)
+		results = append(results, result)
+	}
+
+	c.exitCode = summary.exitCode()
+	if options.candidates != nil && summary.CanConnect > 0 {
+		// any working path answers what -find-proxy asks
+		c.exitCode = exitSuccess
+	}
+
+	if reportErr := reporter.done(summary); reportErr != nil {
+		fmt.Println([]string{"Unable to write results: ", reportErr.Error()})
+		c.exitCode = exitUsage
+		return
+	}
+	if options.outputFile != "" {
+		fmt.Println("Results written to " + options.outputFile)
+	}
+
+	if options.suggestASG {
+		if suggestErr := c.suggestASG(results, options); suggestErr != nil {
+			fmt.Println(suggestErr)
+			c.exitCode = exitUsage
+		}
+	}
 }
 
-type wicRequest struct {
-	host      string
-	port      string
-	url       string
-	hasProxy  bool
-	proxyHost string
-	proxyPort string
+type wicOptions struct {
+	requests   []*wicRequest
+	batch      bool
+	output     string
+	outputFile string
+	wicURL     string
+	proxy      *targetProxy
+	app        string
+	asg        bool
+	suggestASG bool
+	asgFile    string
+	searchOrg  bool
+	ensure     bool
+	appPath    string
+	ephemeral  bool
+	domain     string
+	portTable  bool
+	network    string
+	candidates []*targetProxy
+	asApp      string
+	parallel   int
+	rate       int
+	timeout    time.Duration
+	retries    int
+	tls        transportSettings
+	transport  http.RoundTripper
+	cfToken    bool
+	token      string
+	username   string
+	password   string
+}
+
+// notify prints progress, keeping it out of the way of results written to stdout in a structured format
+func (o *wicOptions) notify(message string) {
+	if o.output == "text" && o.outputFile == "" {
+		fmt.Println([]string{message})
+	} else {
+		fmt.Fprintln(os.Stderr, message)
+	}
 }
 
-type wicResponse struct {
-	LastChecked   int    `json:"lastChecked"`
-	Entry         string `json:"entry"`
-	CanConnect    bool   `json:"canConnect"`
-	HTTPStatus    int    `json:"httpStatus"`
-	ValidHostname bool   `json:"validHostname"`
-	ValidURL      bool   `json:"validUrl"`
-	ResponseTime  int    `json:"responseTime,omitempty"`
+type wicRequest struct {
+	name  string
+	host  string
+	port  string
+	url   string
+	proxy *targetProxy
 }
 
-func (c *WillItConnect) getBaseURL(cliConnection plugin.CliConnection) (*string, []string) {
+func (c *WillItConnect) getOrg(cliConnection plugin.CliConnection) (*plugin_models.GetOrg_Model, []string) {
 
 	currOrg, err := cliConnection.GetCurrentOrg()
 
@@ -114,93 +344,404 @@ func (c *WillItConnect) getBaseURL(cliConnection plugin.CliConnection) (*string,
 		return nil, []string{"Unable to find valid domain, please view cf domains"}
 	}
 
-	baseURL := org.Domains[0].Name
-	if baseURL == "" {
+	if org.Domains[0].Name == "" {
 		return nil, []string{"Unable to find valid domain, please view cf domains"}
 	}
-	return &baseURL, nil
+	return &org, nil
 }
 
-func (c *WillItConnect) parseArgs(args []string, baseURL *string) (*wicRequest, []string) {
+func (c *WillItConnect) parseArgs(args []string) (*wicOptions, []string) {
+	config, configErr := readConfig(configPath())
+	if configErr != nil {
+		return nil, configErr
+	}
+
 	wicFlags := flag.NewFlagSet("wicFlags", flag.ExitOnError)
 
 	hostPtr := wicFlags.String("host", "", "host for connection")
-	portPtr := wicFlags.Int("port", -1, "port for connection")
+	portPtr := wicFlags.String("port", "", "port for connection, or ports such as 5671,5672 or 9092-9094")
 	proxyHostPtr := wicFlags.String("proxyHost", "", "host for proxy")
 	proxyPortPtr := wicFlags.Int("proxyPort", -1, "port for proxy")
+	proxyPtr := wicFlags.String("proxy", "", "proxy url for willitconnect to connect through, http, https or socks5")
+	findProxyPtr := wicFlags.Bool("find-proxy", false, "check the target directly and through each candidate proxy")
+	proxiesPtr := wicFlags.String("proxies", "", "comma separated candidate proxy urls for -find-proxy")
+	proxiesFilePtr := wicFlags.String("proxies-file", "", "file listing candidate proxy urls for -find-proxy, one per line")
+	asAppPtr := wicFlags.String("as-app", "", "check with the proxy environment variables an app runs with")
+	noProxyPtr := wicFlags.String("no-proxy", "", "comma separated hosts, domains and CIDR blocks to connect to without the proxy")
 	routePtr := wicFlags.String("route", "", "route for willitconnect")
+	filePtr := wicFlags.String("file", "", "file of targets to check, - for stdin")
+	outputPtr := wicFlags.String("output", "text", "output format: text, json, yaml, csv or junit")
+	outputFilePtr := wicFlags.String("outputFile", "", "file to write results to")
+	appPtr := wicFlags.String("app", "", "app whose bound services to check")
+	asgPtr := wicFlags.Bool("asg", false, "evaluate the space's security groups against each target")
+	suggestASGPtr := wicFlags.Bool("suggest-asg", false, "suggest the security group rules needed to open failing targets")
+	asgFilePtr := wicFlags.String("asgFile", "", "file to write suggested security group rules to")
+	searchOrgPtr := wicFlags.Bool("searchOrg", false, "search every space in the org for a willitconnect app")
+	ensurePtr := wicFlags.Bool("ensure", false, "deploy willitconnect when it is not reachable")
+	appPathPtr := wicFlags.String("appPath", os.Getenv(appPathEnv), "path to the willitconnect jar deployed by -ensure or -ephemeral")
+	ephemeralPtr := wicFlags.Bool("ephemeral", false, "deploy willitconnect for this run only and delete it afterward")
+	domainPtr := wicFlags.String("domain", "", "domain -ensure or -ephemeral deploy willitconnect on")
+	parallelPtr := wicFlags.Int("parallel", 0, "how many targets to check at once, 1 by default or 8 for a CIDR block")
+	ratePtr := wicFlags.Int("rate", defaultRate, "most calls to willitconnect per second, 0 for no limit")
+	timeoutPtr := wicFlags.Duration("timeout", defaultTimeout, "how long to wait for each call to willitconnect")
+	retriesPtr := wicFlags.Int("retries", defaultRetries, "how many times to retry a call when willitconnect is unreachable or fails")
+	caCertPtr := wicFlags.String("ca-cert", setting(caCertEnv, config.CACert), "CA bundle to trust for willitconnect's certificate")
+	cfTokenPtr := wicFlags.Bool("cf-token", false, "send your cf access token to willitconnect as a bearer token")
+	usernamePtr := wicFlags.String("username", setting(usernameEnv, config.Username), "username for basic auth to willitconnect")
+	passwordPtr := wicFlags.String("password", setting(passwordEnv, config.Password), "password for basic auth to willitconnect")
+	clientCertPtr := wicFlags.String("client-cert", setting(clientCertEnv, config.ClientCert), "client certificate to present to willitconnect")
+	clientKeyPtr := wicFlags.String("client-key", setting(clientKeyEnv, config.ClientKey), "key of the client certificate, when it isn't in the certificate's file")
+	clientProxyPtr := wicFlags.String("client-proxy", setting(clientProxyEnv, config.ClientProxy), "proxy url the plugin uses to reach willitconnect, or direct")
 
 	wicFlags.Parse(args[1:])
 
-	if strings.HasPrefix(*hostPtr, "http://") {
-		*portPtr = 80
+	if !validOutput(*outputPtr) {
+		return nil, []string{"-output must be one of " + strings.Join(outputFormats, ", ")}
 	}
 
-	if strings.HasPrefix(*hostPtr, "https://") {
-		*portPtr = 443
+	wicURL, routeErr := routeURL(*routePtr)
+	if routeErr != nil {
+		return nil, routeErr
+	}
+
+	if *parallelPtr < 0 || *parallelPtr > maxParallel {
+		return nil, []string{fmt.Sprintf("-parallel must be between 1 and %d, or 0 for the default", maxParallel)}
+	}
+	if *ratePtr < 0 || *ratePtr > maxRate {
+		return nil, []string{fmt.Sprintf("-rate must be between 1 and %d, or 0 for no limit", maxRate)}
+	}
+	if *timeoutPtr <= 0 {
+		return nil, []string{"-timeout must be more than 0, such as 30s"}
+	}
+	if *retriesPtr < 0 {
+		return nil, []string{"-retries must be 0 or more"}
+	}
+	if *cfTokenPtr && *usernamePtr != "" {
+		return nil, []string{"-cf-token cannot be combined with -username"}
+	}
+	if *cfTokenPtr && wicURL == "" {
+		// discovery may pick any app named like willitconnect, so the token only goes to a route the user names
+		return nil, []string{"-cf-token requires -route, so your cf token is only sent to a willitconnect you chose"}
+	}
+	if *clientKeyPtr != "" && *clientCertPtr == "" {
+		return nil, []string{"-client-key requires -client-cert"}
+	}
+	proxy, proxyErr := parseProxyFlags(*proxyPtr, *proxyHostPtr, *proxyPortPtr, *noProxyPtr)
+	if proxyErr != nil {
+		return nil, proxyErr
+	}
+	if *clientProxyPtr != "" && *clientProxyPtr != directProxy {
+		if _, err := parseProxyURL(*clientProxyPtr); err != nil {
+			return nil, []string{"Invalid -client-proxy: ", err.Error()}
+		}
 	}
 
-	if *portPtr == -1 || *hostPtr == "" {
-		if len(wicFlags.Args()) == 1 {
-			if strings.HasPrefix(wicFlags.Args()[0], "http://") {
-				*hostPtr = wicFlags.Args()[0]
-				*portPtr = 80
-			} else if strings.HasPrefix(wicFlags.Args()[0], "https://") {
-				*hostPtr = wicFlags.Args()[0]
-				*portPtr = 443
-			} else {
-				return nil, []string{"Usage: cf willitconnect -host=<host> -port=<port>"}
+	options := &wicOptions{output: *outputPtr, outputFile: *outputFilePtr, wicURL: wicURL,
+		proxy: proxy, asg: *asgPtr || *suggestASGPtr,
+		suggestASG: *suggestASGPtr, asgFile: *asgFilePtr, searchOrg: *searchOrgPtr,
+		ensure: *ensurePtr, appPath: *appPathPtr, ephemeral: *ephemeralPtr, domain: *domainPtr,
+		parallel: *parallelPtr, rate: *ratePtr, timeout: *timeoutPtr, retries: *retriesPtr,
+		tls: transportSettings{caCert: *caCertPtr, clientCert: *clientCertPtr, clientKey: *clientKeyPtr,
+			proxy: *clientProxyPtr},
+		cfToken: *cfTokenPtr, username: *usernamePtr, password: *passwordPtr}
+
+	if options.ephemeral {
+		if options.wicURL != "" || options.ensure {
+			return nil, []string{"-ephemeral cannot be combined with -route or -ensure"}
+		}
+		if options.appPath == "" {
+			return nil, []string{"-ephemeral needs -appPath=<willitconnect jar> or " + appPathEnv}
+		}
+	}
+
+	if options.domain != "" && !options.ensure && !options.ephemeral {
+		return nil, []string{"-domain requires -ensure or -ephemeral"}
+	}
+
+	if options.asgFile != "" && !options.suggestASG {
+		return nil, []string{"-asgFile requires -suggest-asg"}
+	}
+	if options.suggestASG && options.asgFile == "" && options.output != "text" && options.outputFile == "" {
+		return nil, []string{"-suggest-asg with -output=" + options.output + " requires -asgFile or -outputFile"}
+	}
+
+	if *asAppPtr != "" {
+		if proxy != nil || *findProxyPtr {
+			return nil, []string{"-as-app cannot be combined with a proxy or -find-proxy"}
+		}
+		options.asApp = *asAppPtr
+	}
+
+	if *findProxyPtr {
+		if *appPtr != "" || *filePtr != "" || proxy != nil {
+			return nil, []string{"-find-proxy cannot be combined with -app, -file or a proxy"}
+		}
+		candidates, candidatesErr := proxyCandidates(*proxiesPtr, *proxiesFilePtr, config.Proxies)
+		if candidatesErr != nil {
+			return nil, candidatesErr
+		}
+		options.candidates = candidates
+	}
+
+	if *appPtr != "" {
+		if *filePtr != "" || *hostPtr != "" || *portPtr != "" || len(wicFlags.Args()) > 0 {
+			return nil, []string{"-app cannot be combined with -file, a host or port"}
+		}
+		options.app = *appPtr
+		options.batch = true
+		return options, nil
+	}
+
+	if *filePtr != "" {
+		if *hostPtr != "" || *portPtr != "" || len(wicFlags.Args()) > 0 {
+			return nil, []string{"-file cannot be combined with a host or port"}
+		}
+		targets, fileErr := readTargetFile(*filePtr)
+		if fileErr != nil {
+			return nil, fileErr
+		}
+		var requests []*wicRequest
+		for _, t := range targets {
+			if t.proxy == nil {
+				t.proxy = proxy
 			}
-		} else {
-			return nil, []string{"Usage: cf willitconnect -host=<host> -port=<port>"}
+			requests = append(requests, newRequest(t.host, t.port, t.proxy))
+		}
+		options.requests = requests
+		options.batch = true
+		if requestErr := validateRequests(options.requests); requestErr != nil {
+			return nil, requestErr
+		}
+		return options, nil
+	}
+
+	address := *hostPtr
+	if address == "" && len(wicFlags.Args()) == 1 {
+		address = wicFlags.Args()[0]
+	}
+	ports, portsErr := parsePorts(*portPtr)
+	if portsErr != nil {
+		return nil, []string{"Invalid -port: ", portsErr.Error()}
+	}
+	if options.candidates != nil && (isCIDR(address) || len(ports) > 1) {
+		return nil, []string{"-find-proxy checks a single host and port"}
+	}
+
+	if isCIDR(address) {
+		addresses, cidrErr := expandCIDR(address)
+		if cidrErr != nil {
+			return nil, []string{"Invalid target: ", cidrErr.Error()}
+		}
+		if len(ports) == 0 {
+			return nil, []string{"Usage: cf willitconnect -host=<cidr> -port=<port>"}
+		}
+		if len(addresses)*len(ports) > maxSweepChecks {
+			return nil, []string{fmt.Sprintf("%s on %d ports is more than %d checks, sweep a smaller block or fewer ports",
+				address, len(ports), maxSweepChecks)}
+		}
+		for _, host := range addresses {
+			for _, port := range ports {
+				options.requests = append(options.requests, newRequest(host, port, proxy))
+			}
+		}
+		options.batch = true
+		options.network = address
+		if options.parallel == 0 {
+			options.parallel = sweepParallel
+		}
+		if requestErr := validateRequests(options.requests); requestErr != nil {
+			return nil, requestErr
+		}
+		return options, nil
+	}
+
+	port := -1
+	if len(ports) > 0 {
+		port = ports[0]
+	}
+	host, port, addressErr := parseAddress(address, port)
+	if addressErr != nil {
+		return nil, []string{"Invalid target: ", addressErr.Error()}
+	}
+	if host == "" || port == -1 {
+		return nil, []string{"Usage: cf willitconnect -host=<host> -port=<port>"}
+	}
+
+	if len(ports) > 1 {
+		for _, port := range ports {
+			options.requests = append(options.requests, newRequest(host, port, proxy))
 		}
+		options.batch = true
+		options.portTable = true
+	} else if options.candidates != nil {
+		options.requests = []*wicRequest{newRequest(host, port, nil)}
+		for _, candidate := range options.candidates {
+			options.requests = append(options.requests, newRequest(host, port, candidate))
+		}
+		options.batch = true
+	} else {
+		options.requests = []*wicRequest{newRequest(host, port, proxy)}
 	}
+	if requestErr := validateRequests(options.requests); requestErr != nil {
+		return nil, requestErr
+	}
+	return options, nil
+}
 
-	wicURL := "https://" + wicRoute + "." + *baseURL
-	if *routePtr != "" {
-		if 2 > strings.Count(*routePtr, ".") {
-			return nil, []string{"-route must be a fqdn"}
+// validateRequests rejects targets that can't be sent to willitconnect, such as hosts containing control characters
+func validateRequests(requests []*wicRequest) []string {
+	for _, request := range requests {
+		if err := request.checkRequest().Validate(); err != nil {
+			return []string{"Invalid target: ", err.Error()}
 		}
+	}
+	return nil
+}
+
+// routeURL returns the willitconnect url for a -route, or an empty url when no route was given
+func routeURL(route string) (string, []string) {
+	if route == "" {
+		return "", nil
+	}
+	if 2 > strings.Count(route, ".") {
+		return "", []string{"-route must be a fqdn"}
+	}
+
+	if strings.HasPrefix(route, "http") {
+		return route + wicPath, nil
+	}
+	return "https://" + route + wicPath, nil
+}
+
+func newRequest(host string, port int, proxy *targetProxy) *wicRequest {
+	request := &wicRequest{host: host, port: strconv.Itoa(port)}
+	if proxy != nil && !proxy.bypasses(host, port) {
+		request.proxy = proxy
+	}
+	return request
+}
 
-		if strings.HasPrefix(*routePtr, "http") {
-			wicURL = *routePtr
-		} else {
-			wicURL = "https://" + *routePtr
+func (r *wicRequest) checkRequest() wicclient.CheckRequest {
+	port, _ := strconv.Atoi(r.port)
+	checkRequest := wicclient.CheckRequest{Host: r.host, Port: port}
+	if r.proxy != nil {
+		checkRequest.ProxyHost, checkRequest.ProxyPort = r.proxy.host, r.proxy.port
+		checkRequest.ProxyScheme, checkRequest.ProxyUser = r.proxy.scheme, r.proxy.user
+	}
+	return checkRequest
+}
+
+// displayProxy is the proxy as results show it, proxyHost:proxyPort for an http proxy without credentials
+// and otherwise a url with its password masked
+func (r *wicRequest) displayProxy() string {
+	checkRequest := r.checkRequest()
+	if r.proxy.scheme == "http" && r.proxy.user == nil {
+		return checkRequest.Proxy()
+	}
+	return checkRequest.ProxyURL().Redacted()
+}
+
+// connect checks a request with willitconnect, returning its answer and how many calls were made for it
+func (c *WillItConnect) connect(request *wicRequest, options *wicOptions, limit <-chan time.Time) (*wicclient.CheckResult, int, []string) {
+	client := wicclient.New(request.url)
+	client.HTTPClient = &http.Client{Timeout: options.timeout, Transport: options.transport}
+	client.Retries = options.retries
+	client.Limit = limit
+	client.Token = options.token
+	client.Username, client.Password = options.username, options.password
+
+	result, err := client.Check(context.Background(), request.checkRequest())
+	switch err := err.(type) {
+	case nil:
+		return result, result.Attempts, nil
+	case *wicclient.UnreachableError:
+		return nil, err.Attempts, []string{"Unable to access willitconnect: ", err.Err.Error()}
+	case *wicclient.InvalidResponseError:
+		return nil, err.Attempts, []string{"Invalid response from willitconnect: ", err.Err.Error()}
+	case *wicclient.TLSError:
+		return nil, err.Attempts, []string{"Unable to verify willitconnect's certificate: ", err.Err.Error() + ". ",
+			"Pass your foundation's CA bundle with -ca-cert or " + caCertEnv + ", or use cf api --skip-ssl-validation"}
+	case *wicclient.StatusError:
+		if hint := statusHint(err.Kind()); hint != "" {
+			return nil, err.Attempts, []string{err.Error() + ". ", hint}
 		}
+		return nil, err.Attempts, []string{err.Error()}
+	default:
+		return nil, 0, []string{err.Error()}
 	}
-	wicURL += wicPath
+}
 
-	hasProxy := false
-	if *proxyHostPtr != "" && *proxyPortPtr != -1 {
-		hasProxy = true
+// statusHint suggests how to fix each kind of status willitconnect's route can respond with
+func statusHint(kind wicclient.StatusKind) string {
+	switch kind {
+	case wicclient.RouteMissing:
+		return "Deploy willitconnect with cf wic-deploy or -ensure, or pass the route it runs on with -route"
+	case wicclient.AuthRequired:
+		return "The route is protected, pass credentials with -cf-token, -username and -password, or -client-cert"
+	case wicclient.AppUnavailable:
+		return "Check that willitconnect is running with cf app and cf logs --recent"
+	case wicclient.ServerError:
+		return "Check cf logs --recent for the willitconnect app to see why it failed"
 	}
-	request := wicRequest{*hostPtr, strconv.Itoa(*portPtr), wicURL, hasProxy, *proxyHostPtr, strconv.Itoa(*proxyPortPtr)}
-	return &request, nil
+	return ""
 }
 
-func (c *WillItConnect) connect(request *wicRequest) ([]string, []string) {
-	var payload []byte
-	if request.hasProxy {
-		payload = []byte(`{"target":"` + request.host + `:` + request.port + `", "http_proxy":"` + request.proxyHost + `:` + request.proxyPort + `"}`)
-	} else {
-		payload = []byte(`{"target":"` + request.host + `:` + request.port + `"}`)
+type wicOutcome struct {
+	response *wicclient.CheckResult
+	attempts int
+	err      []string
+}
+
+// connectAll checks options.requests with up to options.parallel checks at a time, making at most
+// options.rate calls a second, retries included, when it is above 0 so willitconnect isn't overwhelmed. It
+// returns a channel per request that receives its outcome, so results can be reported in order as they arrive.
+func (c *WillItConnect) connectAll(options *wicOptions) []chan wicOutcome {
+	requests, parallel, rate := options.requests, options.parallel, options.rate
+	outcomes := make([]chan wicOutcome, len(requests))
+	for i := range outcomes {
+		outcomes[i] = make(chan wicOutcome, 1)
+	}
+	if parallel < 1 {
+		parallel = 1
 	}
-	req, err := http.NewRequest("POST", request.url, bytes.NewBuffer(payload))
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, []string{"Unable to access willitconnect: ", err.Error()}
+	var limit *time.Ticker
+	var ticks <-chan time.Time
+	if rate > 0 {
+		limit = time.NewTicker(time.Second / time.Duration(rate))
+		ticks = limit.C
 	}
-	defer resp.Body.Close()
 
-	decoder := json.NewDecoder(resp.Body)
-	var body wicResponse
-	decodeErr := decoder.Decode(&body)
-	if decodeErr != nil {
-		return nil, []string{"Invalid response from willitconnect: ", decodeErr.Error()}
+	var workers sync.WaitGroup
+	work := make(chan int)
+	for worker := 0; worker < parallel; worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range work {
+				response, attempts, err := c.connect(requests[i], options, ticks)
+				outcomes[i] <- wicOutcome{response: response, attempts: attempts, err: err}
+			}
+		}()
 	}
+	go func() {
+		for i := range requests {
+			if ticks != nil && i > 0 {
+				<-ticks
+			}
+			work <- i
+		}
+		close(work)
+		// retries wait on the ticker too, so it runs until every check is done
+		workers.Wait()
+		if limit != nil {
+			limit.Stop()
+		}
+	}()
+	return outcomes
+}
+
+func formatResponse(body *wicclient.CheckResult) []string {
 	var response []string
 
 	if body.CanConnect {
@@ -213,5 +754,5 @@ func (c *WillItConnect) connect(request *wicRequest) ([]string, []string) {
 		timeText := fmt.Sprintf("it took %d ms.", body.ResponseTime)
 		response = append(response, timeText)
 	}
-	return response, nil
+	return response
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const configEnv string = "WILLITCONNECT_CONFIG"
+const usernameEnv string = "WILLITCONNECT_USERNAME"
+const passwordEnv string = "WILLITCONNECT_PASSWORD"
+const clientCertEnv string = "WILLITCONNECT_CLIENT_CERT"
+const clientKeyEnv string = "WILLITCONNECT_CLIENT_KEY"
+
+// wicConfig holds settings that are awkward to pass as flags every run, flags and environment variables
+// take precedence over it
+type wicConfig struct {
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	CACert      string   `yaml:"caCert"`
+	ClientCert  string   `yaml:"clientCert"`
+	ClientKey   string   `yaml:"clientKey"`
+	ClientProxy string   `yaml:"clientProxy"`
+	Proxies     []string `yaml:"proxies"`
+}
+
+// configPath is WILLITCONNECT_CONFIG, or willitconnect.yml alongside the cli's own config in $CF_HOME/.cf
+func configPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	home := os.Getenv("CF_HOME")
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	return filepath.Join(home, ".cf", "willitconnect.yml")
+}
+
+// readConfig reads the config at path, a missing config is empty
+func readConfig(path string) (*wicConfig, []string) {
+	var config wicConfig
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &config, nil
+	}
+	if err != nil {
+		return nil, []string{"Unable to read config " + path + ": ", err.Error()}
+	}
+	if err := yaml.Unmarshal(contents, &config); err != nil {
+		return nil, []string{"Invalid config " + path + ": ", err.Error()}
+	}
+	return &config, nil
+}
+
+// setting is the environment variable env when it is set, otherwise the configured value
+func setting(env string, configured string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return configured
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultAppName string = "willitconnect"
+const appPathEnv string = "WILLITCONNECT_APP_PATH"
+
+// healthyTimeout and healthyInterval bound how long a deploy waits for willitconnect to answer on its route
+const healthyTimeout = 3 * time.Minute
+const healthyInterval = 2 * time.Second
+
+// notifySignals and exit are how an ephemeral run hears it was interrupted and then ends, tests replace them
+var notifySignals = signal.Notify
+var exit = os.Exit
+
+type deploySettings struct {
+	name      string
+	path      string
+	domain    string
+	quiet     bool
+	transport http.RoundTripper
+}
+
+type wicManifest struct {
+	Applications []wicManifestApp `yaml:"applications"`
+}
+
+type wicManifestApp struct {
+	Name   string `yaml:"name"`
+	Path   string `yaml:"path"`
+	Memory string `yaml:"memory"`
+	Host   string `yaml:"host"`
+	Domain string `yaml:"domain"`
+}
+
+func (c *WillItConnect) runDeploy(cliConnection plugin.CliConnection, args []string) {
+	config, configErr := readConfig(configPath())
+	if configErr != nil {
+		fmt.Println(configErr)
+		c.exitCode = exitUsage
+		return
+	}
+
+	deployFlags := flag.NewFlagSet("deployFlags", flag.ExitOnError)
+	namePtr := deployFlags.String("name", defaultAppName, "name of the willitconnect app")
+	pathPtr := deployFlags.String("path", os.Getenv(appPathEnv), "path to the willitconnect jar")
+	caCertPtr := deployFlags.String("ca-cert", setting(caCertEnv, config.CACert), "CA bundle to trust for willitconnect's certificate")
+	domainPtr := deployFlags.String("domain", "", "domain to deploy willitconnect on, the first org domain by default")
+	deployFlags.Parse(args[1:])
+
+	if *pathPtr == "" {
+		fmt.Println([]string{"Usage: cf wic-deploy -path=<willitconnect jar> [-name=<app name>], or set " + appPathEnv})
+		c.exitCode = exitUsage
+		return
+	}
+
+	org, cfErr := c.getOrg(cliConnection)
+	if cfErr != nil {
+		fmt.Println(cfErr)
+		c.exitCode = exitCFError
+		return
+	}
+	domain, domainErr := c.deployDomain(cliConnection, org, *domainPtr, "")
+	if domainErr != nil {
+		fmt.Println(domainErr)
+		c.exitCode = exitUsage
+		return
+	}
+
+	transport, tlsErr := newTransport(cliConnection, transportSettings{caCert: *caCertPtr,
+		clientCert: setting(clientCertEnv, config.ClientCert), clientKey: setting(clientKeyEnv, config.ClientKey),
+		proxy: setting(clientProxyEnv, config.ClientProxy)})
+	if tlsErr != nil {
+		fmt.Println(tlsErr)
+		c.exitCode = exitUsage
+		return
+	}
+
+	wicURL, deployErr := c.deploy(cliConnection, deploySettings{name: *namePtr, path: *pathPtr, domain: domain,
+		transport: transport})
+	if deployErr != nil {
+		fmt.Println(deployErr)
+		c.exitCode = exitCFError
+		return
+	}
+	fmt.Println("willitconnect is running at " + wicURL + ", remove it with: cf wic-teardown -name=" + *namePtr)
+}
+
+func (c *WillItConnect) runTeardown(cliConnection plugin.CliConnection, args []string) {
+	teardownFlags := flag.NewFlagSet("teardownFlags", flag.ExitOnError)
+	namePtr := teardownFlags.String("name", defaultAppName, "name of the willitconnect app")
+	teardownFlags.Parse(args[1:])
+
+	if teardownErr := c.teardown(cliConnection, *namePtr, false); teardownErr != nil {
+		fmt.Println(teardownErr)
+		c.exitCode = exitCFError
+		return
+	}
+	fmt.Println("Deleted app " + *namePtr + " and its routes")
+}
+
+// deployDomain returns the domain to deploy willitconnect on: the -domain given, which must be an org or space
+// domain, else the domain of the route discovery chose, else the first org domain
+func (c *WillItConnect) deployDomain(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model, domain string, wicURL string) (string, []string) {
+	domains := c.candidateDomains(cliConnection, org)
+	if domain != "" {
+		for _, candidate := range domains {
+			if strings.EqualFold(candidate, domain) {
+				return candidate, nil
+			}
+		}
+		return "", []string{"Unable to find domain " + domain + " in the org or space, please view cf domains"}
+	}
+
+	chosen := domains[0]
+	if route, err := url.Parse(wicURL); err == nil && wicURL != "" {
+		longest := ""
+		for _, candidate := range domains {
+			if strings.HasSuffix(strings.ToLower(route.Hostname()), "."+strings.ToLower(candidate)) && len(candidate) > len(longest) {
+				longest = candidate
+			}
+		}
+		if longest != "" {
+			chosen = longest
+		}
+	}
+	return chosen, nil
+}
+
+// deploy pushes willitconnect with a generated manifest on settings.domain, then waits for it to answer on its
+// route, which it returns
+func (c *WillItConnect) deploy(cliConnection plugin.CliConnection, settings deploySettings) (string, []string) {
+	domain := settings.domain
+	path, err := filepath.Abs(settings.path)
+	if err == nil {
+		_, err = os.Stat(path)
+	}
+	if err != nil {
+		return "", []string{"Unable to find willitconnect at " + settings.path + ": ", err.Error()}
+	}
+
+	manifest, err := yaml.Marshal(wicManifest{Applications: []wicManifestApp{{
+		Name:   settings.name,
+		Path:   path,
+		Memory: "512M",
+		Host:   settings.name,
+		Domain: domain,
+	}}})
+	if err != nil {
+		return "", []string{"Unable to generate manifest: ", err.Error()}
+	}
+	manifestFile, err := ioutil.TempFile("", "willitconnect-manifest")
+	if err != nil {
+		return "", []string{"Unable to write manifest: ", err.Error()}
+	}
+	defer os.Remove(manifestFile.Name())
+	_, err = manifestFile.Write(manifest)
+	manifestFile.Close()
+	if err != nil {
+		return "", []string{"Unable to write manifest: ", err.Error()}
+	}
+
+	if _, err := c.cliCommand(cliConnection, settings.quiet, "push", settings.name, "-f", manifestFile.Name()); err != nil {
+		return "", []string{"Unable to push " + settings.name + ": ", err.Error()}
+	}
+
+	wicURL := appRouteURL(settings.name, domain)
+	if !waitForWic(wicURL, settings.transport) {
+		return "", []string{"willitconnect did not respond on " + wicURL + " within " + healthyTimeout.String()}
+	}
+	return wicURL, nil
+}
+
+// teardown deletes a willitconnect app along with its routes
+func (c *WillItConnect) teardown(cliConnection plugin.CliConnection, name string, quiet bool) []string {
+	if _, err := c.cliCommand(cliConnection, quiet, "delete", name, "-f", "-r"); err != nil {
+		return []string{"Unable to delete " + name + ": ", err.Error()}
+	}
+	return nil
+}
+
+func (c *WillItConnect) cliCommand(cliConnection plugin.CliConnection, quiet bool, args ...string) ([]string, error) {
+	if quiet {
+		return cliConnection.CliCommandWithoutTerminalOutput(args...)
+	}
+	return cliConnection.CliCommand(args...)
+}
+
+func waitForWic(wicURL string, transport http.RoundTripper) bool {
+	deadline := time.Now().Add(healthyTimeout)
+	for {
+		if probeWicURL(wicURL, transport) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(healthyInterval)
+	}
+}
+
+// ensureWic deploys willitconnect when nothing answers on options.wicURL, and switches options to the deployed route
+func (c *WillItConnect) ensureWic(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model, options *wicOptions) []string {
+	if probeWicURL(strings.TrimSuffix(options.wicURL, wicPath), options.transport) {
+		return nil
+	}
+	if options.appPath == "" {
+		return []string{"willitconnect is not reachable, -ensure needs -appPath=<willitconnect jar> or " + appPathEnv + " to deploy it"}
+	}
+
+	domain, domainErr := c.deployDomain(cliConnection, org, options.domain, options.wicURL)
+	if domainErr != nil {
+		return domainErr
+	}
+
+	options.notify("willitconnect is not reachable, deploying " + defaultAppName + " on domain " + domain)
+	wicURL, deployErr := c.deploy(cliConnection, deploySettings{name: defaultAppName, path: options.appPath, domain: domain,
+		quiet: true, transport: options.transport})
+	if deployErr != nil {
+		return deployErr
+	}
+	options.notify("Using " + wicURL + ", deployed app " + defaultAppName)
+	options.wicURL = wicURL + wicPath
+	return nil
+}
+
+// deployEphemeral deploys a uniquely named willitconnect on a random route for this run only. The returned
+// cleanup deletes the app and its route, and also runs if the run is interrupted.
+func (c *WillItConnect) deployEphemeral(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model, options *wicOptions) (func(), []string) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return func() {}, []string{"Unable to generate an app name: ", err.Error()}
+	}
+	name := defaultAppName + "-" + hex.EncodeToString(suffix)
+	domain, domainErr := c.deployDomain(cliConnection, org, options.domain, "")
+	if domainErr != nil {
+		return func() {}, domainErr
+	}
+	route := appRouteURL(name, domain)
+
+	session, sessionErr := newCCSession(cliConnection, options.transport)
+	if sessionErr != nil {
+		return func() {}, sessionErr
+	}
+
+	var once sync.Once
+	interrupted := make(chan os.Signal, 1)
+	finished := make(chan struct{})
+	remove := func(teardown func() []string) {
+		once.Do(func() {
+			signal.Stop(interrupted)
+			close(finished)
+			if teardownErr := teardown(); teardownErr != nil {
+				options.notify(strings.Join(teardownErr, "") + ", remove it with: cf wic-teardown -name=" + name)
+				return
+			}
+			options.notify("Deleted ephemeral app " + name + " and route " + route)
+		})
+	}
+	cleanup := func() {
+		remove(func() []string { return c.teardown(cliConnection, name, true) })
+	}
+
+	notifySignals(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-interrupted:
+			options.notify("Interrupted, cleaning up")
+			// the cf CLI gets the same interrupt and exits, so the app is deleted through the cloud controller
+			remove(func() []string { return session.deleteApp(name) })
+			exit(exitCFError)
+		case <-finished:
+		}
+	}()
+
+	options.notify("Creating ephemeral app " + name + " with route " + route)
+	wicURL, deployErr := c.deploy(cliConnection, deploySettings{name: name, path: options.appPath, domain: domain,
+		quiet: true, transport: options.transport})
+	if deployErr != nil {
+		return cleanup, deployErr
+	}
+	options.notify("Using " + wicURL + ", deployed ephemeral app " + name)
+	options.wicURL = wicURL + wicPath
+	return cleanup, nil
+}
+
+// ccSession deletes apps through the cloud controller rather than the cf CLI, which exits on the same interrupt
+// as the plugin. It is set up before the push, while the CLI is still there to ask.
+type ccSession struct {
+	api       string
+	token     string
+	spaceGuid string
+	client    *http.Client
+}
+
+// ccResources is the guids of a page of cloud controller resources
+type ccResources struct {
+	Resources []struct {
+		Metadata struct {
+			Guid string `json:"guid"`
+		} `json:"metadata"`
+	} `json:"resources"`
+}
+
+func newCCSession(cliConnection plugin.CliConnection, transport http.RoundTripper) (*ccSession, []string) {
+	api, err := cliConnection.ApiEndpoint()
+	if err != nil || api == "" {
+		return nil, []string{"Unable to find the cf api, please cf login"}
+	}
+	token, err := cliConnection.AccessToken()
+	if err != nil || token == "" {
+		return nil, []string{"Unable to get an access token, please cf login"}
+	}
+	space, err := cliConnection.GetCurrentSpace()
+	if err != nil || space.SpaceFields.Guid == "" {
+		return nil, []string{"Unable to find current space, please view cf target"}
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = "bearer " + token
+	}
+	return &ccSession{api: strings.TrimSuffix(api, "/"), token: token, spaceGuid: space.SpaceFields.Guid,
+		client: &http.Client{Timeout: probeTimeout, Transport: transport}}, nil
+}
+
+// deleteApp deletes the app called name in the session's space, then the routes it had
+func (s *ccSession) deleteApp(name string) []string {
+	var apps ccResources
+	if err := s.call("GET", "/v2/apps?q=name:"+url.QueryEscape(name)+"&q=space_guid:"+s.spaceGuid, &apps); err != nil {
+		return []string{"Unable to find " + name + ": ", err.Error()}
+	}
+	for _, app := range apps.Resources {
+		var routes ccResources
+		if err := s.call("GET", "/v2/apps/"+app.Metadata.Guid+"/routes", &routes); err != nil {
+			return []string{"Unable to find the routes of " + name + ": ", err.Error()}
+		}
+		if err := s.call("DELETE", "/v2/apps/"+app.Metadata.Guid+"?recursive=true", nil); err != nil {
+			return []string{"Unable to delete " + name + ": ", err.Error()}
+		}
+		for _, route := range routes.Resources {
+			if err := s.call("DELETE", "/v2/routes/"+route.Metadata.Guid, nil); err != nil {
+				return []string{"Unable to delete the route of " + name + ": ", err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ccSession) call(method string, path string, result interface{}) error {
+	req, err := http.NewRequest(method, s.api+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", s.token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s responded with %s", method, path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
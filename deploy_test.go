@@ -0,0 +1,258 @@
+package main_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Deploying willitconnect", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+	var jar string
+	var manifest string
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+
+		file, err := ioutil.TempFile("", "willitconnect.jar")
+		Expect(err).NotTo(HaveOccurred())
+		file.Close()
+		jar = file.Name()
+
+		manifest = ""
+		pushStub := func(args ...string) ([]string, error) {
+			if args[0] == "push" {
+				contents, err := ioutil.ReadFile(args[3])
+				Expect(err).NotTo(HaveOccurred())
+				manifest = string(contents)
+			}
+			return nil, nil
+		}
+		fakeCliConnection.CliCommandStub = pushStub
+		fakeCliConnection.CliCommandWithoutTerminalOutputStub = pushStub
+	})
+
+	AfterEach(func() {
+		os.Remove(jar)
+	})
+
+	It("pushes willitconnect with a generated manifest and waits for it", func() {
+		defer gock.Off()
+		gock.New("https://wic.cfapps.io").Get("/").Reply(200)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"wic-deploy", "-path=" + jar, "-name=wic"})
+		})
+		Expect(fakeCliConnection.CliCommandCallCount()).To(Equal(1))
+		args := fakeCliConnection.CliCommandArgsForCall(0)
+		Expect(args[:3]).To(Equal([]string{"push", "wic", "-f"}))
+		Expect(manifest).To(ContainSubstring("name: wic"))
+		Expect(manifest).To(ContainSubstring("path: " + jar))
+		Expect(manifest).To(ContainSubstring("host: wic"))
+		Expect(manifest).To(ContainSubstring("domain: cfapps.io"))
+		Expect(output).To(ContainSubstrings([]string{"willitconnect is running at https://wic.cfapps.io, remove it with: cf wic-teardown -name=wic"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(0))
+	})
+
+	It("pushes onto the domain given with -domain", func() {
+		fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "dev"}}, nil)
+		fakeCliConnection.GetSpaceReturns(plugin_models.GetSpace_Model{Domains: []plugin_models.GetSpace_Domains{{Name: "apps.internal.com"}}}, nil)
+		defer gock.Off()
+		gock.New("https://wic.apps.internal.com").Get("/").Reply(200)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"wic-deploy", "-path=" + jar, "-name=wic", "-domain=apps.internal.com"})
+		})
+		Expect(manifest).To(ContainSubstring("domain: apps.internal.com"))
+		Expect(output).To(ContainSubstrings([]string{"willitconnect is running at https://wic.apps.internal.com"}))
+	})
+
+	It("rejects a domain that isn't in the org or space", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"wic-deploy", "-path=" + jar, "-domain=example.com"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Unable to find domain example.com in the org or space, please view cf domains"}))
+		Expect(fakeCliConnection.CliCommandCallCount()).To(Equal(0))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("requires the willitconnect jar", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"wic-deploy"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Usage: cf wic-deploy -path=<willitconnect jar>"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("tears down the app and its routes", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"wic-teardown"})
+		})
+		Expect(fakeCliConnection.CliCommandArgsForCall(0)).To(Equal([]string{"delete", "willitconnect", "-f", "-r"}))
+		Expect(output).To(ContainSubstrings([]string{"Deleted app willitconnect and its routes"}))
+	})
+
+	Context("-ensure", func() {
+		It("deploys willitconnect when it isn't reachable, then checks", func() {
+			defer gock.Off()
+			gock.New(wicURL).Get("/").Reply(404).SetHeader("X-Cf-Routererror", "unknown_route")
+			gock.New(wicURL).Get("/").Reply(200)
+			gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ensure", "-appPath=" + jar})
+			})
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(0)[0]).To(Equal("push"))
+			Expect(output).To(ContainSubstrings([]string{"willitconnect is not reachable, deploying willitconnect"}))
+			Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		})
+
+		It("deploys on the domain of the route it was given", func() {
+			fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "dev"}}, nil)
+			fakeCliConnection.GetSpaceReturns(plugin_models.GetSpace_Model{Domains: []plugin_models.GetSpace_Domains{{Name: "apps.internal.com"}}}, nil)
+			defer gock.Off()
+			gock.New("https://willitconnect.apps.internal.com").Get("/").Reply(404).SetHeader("X-Cf-Routererror", "unknown_route")
+			gock.New("https://willitconnect.apps.internal.com").Get("/").Reply(200)
+			gock.New("https://willitconnect.apps.internal.com").Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ensure", "-appPath=" + jar,
+					"-route=willitconnect.apps.internal.com"})
+			})
+			Expect(manifest).To(ContainSubstring("domain: apps.internal.com"))
+			Expect(output).To(ContainSubstrings([]string{"willitconnect is not reachable, deploying willitconnect on domain apps.internal.com"}))
+			Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		})
+
+		It("doesn't deploy when willitconnect is reachable", func() {
+			defer gock.Off()
+			gock.New(wicURL).Get("/").Reply(200)
+			gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ensure"})
+			})
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(0))
+			Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		})
+	})
+
+	Context("-ephemeral", func() {
+		BeforeEach(func() {
+			fakeCliConnection.ApiEndpointReturns("https://api.cfapps.io", nil)
+			fakeCliConnection.AccessTokenReturns("bearer abc", nil)
+			fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "dev", Guid: "space-guid"}}, nil)
+		})
+
+		It("deploys a uniquely named willitconnect, checks, then deletes it", func() {
+			defer gock.Off()
+			gock.New("https://willitconnect-.*.cfapps.io").Get("/").Reply(200)
+			gock.New("https://willitconnect-.*.cfapps.io").Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar})
+			})
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(2))
+			push := fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(0)
+			Expect(push[0]).To(Equal("push"))
+			Expect(push[1]).To(MatchRegexp("^willitconnect-[0-9a-f]{8}$"))
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(1)).To(Equal([]string{"delete", push[1], "-f", "-r"}))
+			Expect(output).To(ContainSubstrings([]string{"Creating ephemeral app " + push[1] + " with route https://" + push[1] + ".cfapps.io"}))
+			Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+			Expect(output).To(ContainSubstrings([]string{"Deleted ephemeral app " + push[1] + " and route https://" + push[1] + ".cfapps.io"}))
+		})
+
+		It("deletes the app when the deploy fails", func() {
+			fakeCliConnection.CliCommandWithoutTerminalOutputStub = nil
+			fakeCliConnection.CliCommandWithoutTerminalOutputReturns(nil, errors.New("push failed"))
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar})
+			})
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(1)[0]).To(Equal("delete"))
+			Expect(output).To(ContainSubstrings([]string{"Unable to push"}))
+			Expect(output).To(ContainSubstrings([]string{"remove it with: cf wic-teardown -name=willitconnect-"}))
+			Expect(willItConnectPlugin.ExitCode()).To(Equal(3))
+		})
+
+		It("deploys on the domain given with -domain", func() {
+			fakeCliConnection.GetSpaceReturns(plugin_models.GetSpace_Model{Domains: []plugin_models.GetSpace_Domains{{Name: "apps.internal.com"}}}, nil)
+			defer gock.Off()
+			gock.New("https://willitconnect-.*.apps.internal.com").Get("/").Reply(200)
+			gock.New("https://willitconnect-.*.apps.internal.com").Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar,
+					"-domain=apps.internal.com"})
+			})
+			push := fakeCliConnection.CliCommandWithoutTerminalOutputArgsForCall(0)
+			Expect(manifest).To(ContainSubstring("domain: apps.internal.com"))
+			Expect(output).To(ContainSubstrings([]string{"Creating ephemeral app " + push[1] + " with route https://" + push[1] + ".apps.internal.com"}))
+			Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		})
+
+		It("deletes the app through the cloud controller when interrupted, as the cf CLI exits too", func() {
+			interrupt, exited, restore := SimulateInterrupts()
+			defer restore()
+			fakeCliConnection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+				interrupt(os.Interrupt)
+				Eventually(exited).Should(Receive(Equal(3)))
+				return nil, errors.New("cf exited")
+			}
+			defer gock.Off()
+			cc := "https://api.cfapps.io"
+			gock.New(cc).Get("/v2/apps/app-guid/routes").MatchHeader("Authorization", "^bearer abc$").
+				Reply(200).JSON(`{"resources": [{"metadata": {"guid": "route-guid"}}]}`)
+			gock.New(cc).Get("/v2/apps").MatchParam("q", "space_guid:space-guid").MatchHeader("Authorization", "^bearer abc$").
+				Reply(200).JSON(`{"resources": [{"metadata": {"guid": "app-guid"}}]}`)
+			gock.New(cc).Delete("/v2/apps/app-guid").MatchParam("recursive", "true").Reply(204)
+			gock.New(cc).Delete("/v2/routes/route-guid").Reply(204)
+
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar})
+			})
+			Expect(gock.IsDone()).To(BeTrue())
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(1))
+			Expect(output).To(ContainSubstrings([]string{"Interrupted, cleaning up"}))
+			Expect(output).To(ContainSubstrings([]string{"Deleted ephemeral app willitconnect-"}))
+		})
+
+		It("needs the cf api to deploy", func() {
+			fakeCliConnection.AccessTokenReturns("", errors.New("not logged in"))
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar})
+			})
+			Expect(output).To(ContainSubstrings([]string{"Unable to get an access token, please cf login"}))
+			Expect(fakeCliConnection.CliCommandWithoutTerminalOutputCallCount()).To(Equal(0))
+			Expect(willItConnectPlugin.ExitCode()).To(Equal(3))
+		})
+
+		It("can't be combined with -route", func() {
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-ephemeral", "-appPath=" + jar, "-route=wic.cfapps.io"})
+			})
+			Expect(output).To(ContainSubstrings([]string{"-ephemeral cannot be combined with -route or -ensure"}))
+		})
+	})
+
+	It("requires -ensure or -ephemeral for -domain", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-domain=cfapps.io"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"-domain requires -ensure or -ephemeral"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+})
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/cli/plugin"
+	"github.com/cloudfoundry/cli/plugin/models"
+)
+
+const probeTimeout = 5 * time.Second
+
+type orgApps struct {
+	Resources []struct {
+		Metadata struct {
+			Guid string `json:"guid"`
+		} `json:"metadata"`
+		Entity struct {
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"entity"`
+	} `json:"resources"`
+}
+
+type appRoutes struct {
+	Resources []struct {
+		Entity struct {
+			Host   string `json:"host"`
+			Domain struct {
+				Entity struct {
+					Name string `json:"name"`
+				} `json:"entity"`
+			} `json:"domain"`
+		} `json:"entity"`
+	} `json:"resources"`
+}
+
+func isWicApp(name string) bool {
+	return strings.Contains(strings.ToLower(name), wicRoute)
+}
+
+func appRouteURL(host string, domain string) string {
+	if host == "" {
+		return "https://" + domain
+	}
+	return "https://" + host + "." + domain
+}
+
+// discoverWicURL finds the willitconnect app's route, returning it with the reason it was chosen.
+// A willitconnect app in the current space wins, then one anywhere in the org with -searchOrg,
+// then the first org or space domain with a responding willitconnect route, and finally the first org domain.
+func (c *WillItConnect) discoverWicURL(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model, options *wicOptions) (string, string) {
+	if apps, err := cliConnection.GetApps(); err == nil {
+		var found *plugin_models.GetAppsModel
+		for i, app := range apps {
+			if !isWicApp(app.Name) || len(app.Routes) == 0 {
+				continue
+			}
+			if found == nil || (found.State != "started" && app.State == "started") {
+				found = &apps[i]
+			}
+		}
+		if found != nil {
+			return appRouteURL(found.Routes[0].Host, found.Routes[0].Domain.Name),
+				"found app " + found.Name + " in the current space"
+		}
+	}
+
+	if options.searchOrg {
+		if wicURL, name := c.searchOrgApps(cliConnection, org); wicURL != "" {
+			return wicURL, "found app " + name + " in org " + org.Name
+		}
+	}
+
+	domains := c.candidateDomains(cliConnection, org)
+	if len(domains) == 1 {
+		return appRouteURL(wicRoute, domains[0]), "the only domain is " + domains[0]
+	}
+	for _, domain := range domains {
+		if probeWicURL(appRouteURL(wicRoute, domain), options.transport) {
+			return appRouteURL(wicRoute, domain), "it responded on domain " + domain
+		}
+	}
+	return appRouteURL(wicRoute, domains[0]), "no willitconnect app or route was found, assuming the first domain " + domains[0]
+}
+
+// searchOrgApps looks for a willitconnect app in any space of the org, returning its route and name
+func (c *WillItConnect) searchOrgApps(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model) (string, string) {
+	output, err := cliConnection.CliCommandWithoutTerminalOutput("curl", "/v2/apps?q=organization_guid:"+org.Guid)
+	if err != nil {
+		return "", ""
+	}
+	var apps orgApps
+	if json.Unmarshal([]byte(strings.Join(output, "\n")), &apps) != nil {
+		return "", ""
+	}
+
+	for _, app := range apps.Resources {
+		if !isWicApp(app.Entity.Name) {
+			continue
+		}
+		output, err := cliConnection.CliCommandWithoutTerminalOutput("curl", "/v2/apps/"+app.Metadata.Guid+"/routes?inline-relations-depth=1")
+		if err != nil {
+			continue
+		}
+		var routes appRoutes
+		if json.Unmarshal([]byte(strings.Join(output, "\n")), &routes) != nil || len(routes.Resources) == 0 {
+			continue
+		}
+		route := routes.Resources[0].Entity
+		return appRouteURL(route.Host, route.Domain.Entity.Name), app.Entity.Name
+	}
+	return "", ""
+}
+
+// candidateDomains lists the org domains followed by any other domains of the current space
+func (c *WillItConnect) candidateDomains(cliConnection plugin.CliConnection, org *plugin_models.GetOrg_Model) []string {
+	var domains []string
+	seen := map[string]bool{}
+	add := func(domain string) {
+		if domain != "" && !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+
+	for _, domain := range org.Domains {
+		add(domain.Name)
+	}
+	if currSpace, err := cliConnection.GetCurrentSpace(); err == nil && currSpace.SpaceFields.Name != "" {
+		if space, err := cliConnection.GetSpace(currSpace.SpaceFields.Name); err == nil {
+			for _, domain := range space.Domains {
+				add(domain.Name)
+			}
+		}
+	}
+	return domains
+}
+
+// probeWicURL reports whether anything answers on a willitconnect route, the gorouter
+// marks routes with no app behind them with an X-Cf-Routererror header
+func probeWicURL(wicURL string, transport http.RoundTripper) bool {
+	client := &http.Client{Timeout: probeTimeout, Transport: transport}
+	resp, err := client.Get(wicURL)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("X-Cf-Routererror") == "" && resp.StatusCode != http.StatusNotFound
+}
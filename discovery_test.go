@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Discovering willitconnect", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Guid: "org-guid", Name: "org", Domains: []plugin_models.GetOrg_Domains{
+			{Name: "private.example.com"},
+			{Name: "cfapps.io"},
+		}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	run := func(wicHost string, args ...string) []string {
+		defer gock.Off()
+		gock.New(wicHost).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+		return CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, append([]string{"willitconnect", "-host=foo.com", "-port=80"}, args...))
+		})
+	}
+
+	It("uses the route of a willitconnect app in the current space", func() {
+		fakeCliConnection.GetAppsReturns([]plugin_models.GetAppsModel{
+			{Name: "other", Routes: []plugin_models.GetAppsRouteSummary{{Host: "other", Domain: plugin_models.GetAppsDomainFields{Name: "cfapps.io"}}}},
+			{Name: "willitconnect-stopped", State: "stopped", Routes: []plugin_models.GetAppsRouteSummary{{Host: "wic-old", Domain: plugin_models.GetAppsDomainFields{Name: "cfapps.io"}}}},
+			{Name: "willitconnect", State: "started", Routes: []plugin_models.GetAppsRouteSummary{{Host: "wic-123", Domain: plugin_models.GetAppsDomainFields{Name: "apps.example.com"}}}},
+		}, nil)
+
+		output := run("https://wic-123.apps.example.com")
+		Expect(output).To(ContainSubstrings([]string{"Using https://wic-123.apps.example.com, found app willitconnect in the current space"}))
+		Expect(output).To(ContainSubstrings([]string{"WillItConnect:", "https://wic-123.apps.example.com" + wicPath}))
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("searches the org when asked", func() {
+		fakeCliConnection.CliCommandWithoutTerminalOutputStub = func(args ...string) ([]string, error) {
+			switch args[1] {
+			case "/v2/apps?q=organization_guid:org-guid":
+				return []string{`{"resources": [{"metadata": {"guid": "app-guid"}, "entity": {"name": "willitconnect"}}]}`}, nil
+			case "/v2/apps/app-guid/routes?inline-relations-depth=1":
+				return []string{`{"resources": [{"entity": {"host": "wic", "domain": {"entity": {"name": "shared.example.com"}}}}]}`}, nil
+			}
+			return nil, nil
+		}
+
+		output := run("https://wic.shared.example.com", "-searchOrg")
+		Expect(output).To(ContainSubstrings([]string{"Using https://wic.shared.example.com, found app willitconnect in org org"}))
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("tries each domain until a willitconnect route responds", func() {
+		defer gock.Off()
+		gock.New("https://willitconnect.private.example.com").Get("/").Reply(404).SetHeader("X-Cf-Routererror", "unknown_route")
+		gock.New("https://willitconnect.cfapps.io").Get("/").Reply(200)
+
+		output := run(wicURL)
+		Expect(output).To(ContainSubstrings([]string{"Using https://willitconnect.cfapps.io, it responded on domain cfapps.io"}))
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("includes space domains", func() {
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{{Name: "private.example.com"}}}, nil)
+		fakeCliConnection.GetCurrentSpaceReturns(plugin_models.Space{SpaceFields: plugin_models.SpaceFields{Name: "dev"}}, nil)
+		fakeCliConnection.GetSpaceReturns(plugin_models.GetSpace_Model{Domains: []plugin_models.GetSpace_Domains{{Name: "cfapps.io"}}}, nil)
+		defer gock.Off()
+		gock.New("https://willitconnect.cfapps.io").Get("/").Reply(200)
+
+		output := run(wicURL)
+		Expect(output).To(ContainSubstrings([]string{"Using https://willitconnect.cfapps.io, it responded on domain cfapps.io"}))
+	})
+
+	It("falls back to the first domain when nothing responds", func() {
+		output := run("https://willitconnect.private.example.com")
+		Expect(output).To(ContainSubstrings([]string{"Using https://willitconnect.private.example.com, no willitconnect app or route was found, assuming the first domain private.example.com"}))
+	})
+
+	It("doesn't discover anything when a route is provided", func() {
+		output := run("https://willitconnect-smoke-test.cfapps.io", "-route=willitconnect-smoke-test.cfapps.io")
+		Expect(output).NotTo(ContainSubstrings([]string{"Using"}))
+		Expect(fakeCliConnection.GetAppsCallCount()).To(Equal(0))
+	})
+})
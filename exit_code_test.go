@@ -0,0 +1,75 @@
+package main_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Exit codes", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	run := func(args ...string) int {
+		CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, append([]string{"willitconnect"}, args...))
+		})
+		return willItConnectPlugin.ExitCode()
+	}
+
+	It("is 0 when the target can connect", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+		Expect(run("-host=foo.com", "-port=80")).To(Equal(0))
+	})
+
+	It("is 1 when the target can't connect", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(badRequest).Reply(200).JSON(badResponse)
+		Expect(run("-host=bar.com", "-port=80")).To(Equal(1))
+	})
+
+	It("is 2 for a usage error", func() {
+		Expect(run("blah")).To(Equal(2))
+	})
+
+	It("is 2 for a target that can't be sent to willitconnect", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com\x00", "-port=80"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Invalid target: ", `Invalid host "foo.com\x00": contains whitespace or control characters`}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("is 3 when the CF context is unavailable", func() {
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{}, errors.New("No org!"))
+		Expect(run("-host=foo.com", "-port=80")).To(Equal(3))
+	})
+
+	It("is 4 when willitconnect returns an invalid response", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).BodyString("totes")
+		Expect(run("-host=foo.com", "-port=80")).To(Equal(4))
+	})
+
+	It("resets between runs", func() {
+		Expect(run("blah")).To(Equal(2))
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+		Expect(run("-host=foo.com", "-port=80")).To(Equal(0))
+	})
+})
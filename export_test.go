@@ -0,0 +1,16 @@
+package main
+
+import "os"
+
+// SimulateInterrupts lets a test interrupt an ephemeral run without signalling the test process, which the
+// test runner would take as its own interrupt. Instead of exiting, an interrupted run sends its exit code on
+// the returned channel. The returned func puts signals and exit back.
+func SimulateInterrupts() (func(os.Signal), <-chan int, func()) {
+	registered := make(chan chan<- os.Signal, 1)
+	exited := make(chan int, 1)
+	oldNotify, oldExit := notifySignals, exit
+	notifySignals = func(c chan<- os.Signal, sig ...os.Signal) { registered <- c }
+	exit = func(code int) { exited <- code }
+	interrupt := func(sig os.Signal) { (<-registered) <- sig }
+	return interrupt, exited, func() { notifySignals, exit = oldNotify, oldExit }
+}
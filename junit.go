@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// junitReporter writes one testcase per target, failing the targets willitconnect could not reach
+type junitReporter struct {
+	out   io.Writer
+	cases []junitTestCase
+	time  int
+}
+
+func (r *junitReporter) checking(request *wicRequest) {}
+
+func (r *junitReporter) checked(result wicResult) {
+	name := result.Target
+	if result.Name != "" {
+		name = result.Name + " " + name
+	}
+	if result.Proxy != "" {
+		name += " via " + result.Proxy
+	}
+	testCase := junitTestCase{ClassName: "willitconnect", Name: name, Time: junitSeconds(result.ResponseTime)}
+	r.time += result.ResponseTime
+
+	switch {
+	case result.err != nil:
+		testCase.Error = &junitMessage{Message: result.Error, Type: "willitconnect", Contents: result.Error}
+	case !result.CanConnect:
+		contents, _ := json.MarshalIndent(result.response, "", "  ")
+		testCase.Failure = &junitMessage{Message: "I am unable to connect", Type: "connectivity", Contents: string(contents)}
+	}
+	r.cases = append(r.cases, testCase)
+}
+
+func (r *junitReporter) done(summary wicSummary) error {
+	suites := junitTestSuites{Suites: []junitTestSuite{{
+		Name:      "willitconnect",
+		Tests:     summary.Total,
+		Failures:  summary.CannotConnect,
+		Errors:    summary.Errors,
+		Time:      junitSeconds(r.time),
+		TestCases: r.cases,
+	}}}
+
+	if _, err := io.WriteString(r.out, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(r.out)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(r.out, "\n")
+	return err
+}
+
+func junitSeconds(ms int) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("JUnit output", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+	var dir string
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+		var err error
+		dir, err = ioutil.TempDir("", "wic-junit")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("writes a testcase per target to the output file", func() {
+		targets := filepath.Join(dir, "targets")
+		report := filepath.Join(dir, "report.xml")
+		Expect(ioutil.WriteFile(targets, []byte("foo.com:80\nbar.com:80\nbaz.com:80\n"), 0600)).To(Succeed())
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponseWithTime)
+		gock.New(wicURL).Post(wicPath).JSON(badRequest).Reply(200).JSON(badResponse)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"baz.com:80"}`).Reply(200).BodyString("totes")
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-file=" + targets, "-output=junit", "-outputFile=" + report})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Results written to " + report}))
+
+		contents, err := ioutil.ReadFile(report)
+		Expect(err).NotTo(HaveOccurred())
+		var suites struct {
+			Suites []struct {
+				Tests     int `xml:"tests,attr"`
+				Failures  int `xml:"failures,attr"`
+				Errors    int `xml:"errors,attr"`
+				TestCases []struct {
+					Name    string `xml:"name,attr"`
+					Time    string `xml:"time,attr"`
+					Failure *struct {
+						Message string `xml:"message,attr"`
+					} `xml:"failure"`
+					Error *struct {
+						Message string `xml:"message,attr"`
+					} `xml:"error"`
+				} `xml:"testcase"`
+			} `xml:"testsuite"`
+		}
+		Expect(xml.Unmarshal(contents, &suites)).To(Succeed())
+		Expect(suites.Suites).To(HaveLen(1))
+		suite := suites.Suites[0]
+		Expect(suite.Tests).To(Equal(3))
+		Expect(suite.Failures).To(Equal(1))
+		Expect(suite.Errors).To(Equal(1))
+		Expect(suite.TestCases[0].Name).To(Equal("foo.com:80"))
+		Expect(suite.TestCases[0].Time).To(Equal("0.003"))
+		Expect(suite.TestCases[0].Failure).To(BeNil())
+		Expect(suite.TestCases[1].Failure.Message).To(Equal("I am unable to connect"))
+		Expect(suite.TestCases[2].Error.Message).To(HavePrefix("Invalid response from willitconnect: "))
+	})
+})
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gambtho/cf_will_it_connect_plugin/wicclient"
+	"gopkg.in/yaml.v2"
+)
+
+var outputFormats = []string{"text", "json", "yaml", "csv", "junit"}
+
+// wicResult is the documented, machine readable result of checking a single target
+type wicResult struct {
+	Target        string     `json:"target" yaml:"target"`
+	Host          string     `json:"host" yaml:"host"`
+	Port          int        `json:"port" yaml:"port"`
+	Proxy         string     `json:"proxy" yaml:"proxy"`
+	WillItConnect string     `json:"willItConnect" yaml:"willItConnect"`
+	CanConnect    bool       `json:"canConnect" yaml:"canConnect"`
+	HTTPStatus    int        `json:"httpStatus" yaml:"httpStatus"`
+	ValidHostname bool       `json:"validHostname" yaml:"validHostname"`
+	ValidURL      bool       `json:"validUrl" yaml:"validUrl"`
+	ResponseTime  int        `json:"responseTime" yaml:"responseTime"`
+	LastChecked   int        `json:"lastChecked" yaml:"lastChecked"`
+	Entry         string     `json:"entry" yaml:"entry"`
+	Error         string     `json:"error" yaml:"error"`
+	Name          string     `json:"name" yaml:"name"`
+	Attempts      int        `json:"attempts" yaml:"attempts"`
+	ASG           *asgReport `json:"asg,omitempty" yaml:"asg,omitempty"`
+
+	request  *wicRequest
+	response *wicclient.CheckResult
+	err      []string
+}
+
+var csvHeader = []string{"target", "host", "port", "proxy", "willItConnect", "canConnect", "httpStatus",
+	"validHostname", "validUrl", "responseTime", "lastChecked", "entry", "error", "name", "asgPermitted", "attempts"}
+
+func newResult(request *wicRequest, response *wicclient.CheckResult, attempts int, err []string) wicResult {
+	port, _ := strconv.Atoi(request.port)
+	result := wicResult{
+		Target:        request.checkRequest().Target(),
+		Host:          request.host,
+		Port:          port,
+		WillItConnect: request.url,
+		Name:          request.name,
+		Attempts:      attempts,
+		request:       request,
+		response:      response,
+		err:           err,
+	}
+	if request.proxy != nil {
+		result.Proxy = request.displayProxy()
+	}
+	if err != nil {
+		result.Error = strings.Join(err, "")
+	}
+	if response != nil {
+		result.CanConnect = response.CanConnect
+		result.HTTPStatus = response.HTTPStatus
+		result.ValidHostname = response.ValidHostname
+		result.ValidURL = response.ValidURL
+		result.ResponseTime = response.ResponseTime
+		result.LastChecked = response.LastChecked
+		result.Entry = response.Entry
+	}
+	return result
+}
+
+func (r *wicResult) csvRecord() []string {
+	asgPermitted := ""
+	if r.ASG != nil {
+		asgPermitted = strconv.FormatBool(r.ASG.Permitted)
+	}
+	return []string{r.Target, r.Host, strconv.Itoa(r.Port), r.Proxy, r.WillItConnect,
+		strconv.FormatBool(r.CanConnect), strconv.Itoa(r.HTTPStatus), strconv.FormatBool(r.ValidHostname),
+		strconv.FormatBool(r.ValidURL), strconv.Itoa(r.ResponseTime), strconv.Itoa(r.LastChecked), r.Entry, r.Error, r.Name, asgPermitted,
+		strconv.Itoa(r.Attempts)}
+}
+
+// wicReporter displays results as targets are checked
+type wicReporter interface {
+	checking(request *wicRequest)
+	checked(result wicResult)
+	done(summary wicSummary) error
+}
+
+func validOutput(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func newReporter(options *wicOptions, out io.Writer) wicReporter {
+	switch options.output {
+	case "json", "yaml", "csv":
+		return &structuredReporter{out: out, format: options.output}
+	case "junit":
+		return &junitReporter{out: out}
+	}
+	if options.portTable || options.network != "" || options.candidates != nil {
+		return &tableReporter{out: out, network: options.network, paths: options.candidates != nil}
+	}
+	return &textReporter{out: out, batch: options.batch}
+}
+
+type textReporter struct {
+	out   io.Writer
+	batch bool
+}
+
+func (r *textReporter) checking(request *wicRequest) {
+	if request.name != "" {
+		fmt.Fprintln(r.out, []string{"Service: " + request.name})
+	}
+	fmt.Fprintln(r.out, []string{"Host: ", request.host, " - Port: ", request.port, " - WillItConnect: ", request.url})
+	if request.proxy != nil {
+		fmt.Fprintln(r.out, []string{"Proxy: " + request.displayProxy()})
+	}
+}
+
+func (r *textReporter) checked(result wicResult) {
+	if result.err != nil {
+		fmt.Fprintln(r.out, result.err)
+	} else {
+		fmt.Fprintln(r.out, formatResponse(result.response))
+	}
+	if line := formatAttempts(result); line != nil {
+		fmt.Fprintln(r.out, line)
+	}
+	if result.ASG != nil {
+		for _, line := range formatASG(&result) {
+			fmt.Fprintln(r.out, line)
+		}
+	}
+}
+
+func (r *textReporter) done(summary wicSummary) error {
+	if r.batch {
+		fmt.Fprintln(r.out, summary.String())
+	}
+	return nil
+}
+
+// tableReporter displays the checks of one host on several ports, of a CIDR block, or of one target
+// through each candidate proxy, as a table with a row per port, address or path
+type tableReporter struct {
+	out     io.Writer
+	network string
+	paths   bool
+	started bool
+	results []wicResult
+}
+
+func (r *tableReporter) checking(request *wicRequest) {
+	if r.started {
+		return
+	}
+	r.started = true
+	switch {
+	case r.network != "":
+		fmt.Fprintln(r.out, []string{"Network: ", r.network, " - WillItConnect: ", request.url})
+	case r.paths:
+		fmt.Fprintln(r.out, []string{"Host: ", request.host, " - Port: ", request.port, " - WillItConnect: ", request.url})
+		return
+	default:
+		fmt.Fprintln(r.out, []string{"Host: ", request.host, " - WillItConnect: ", request.url})
+	}
+	if request.proxy != nil {
+		fmt.Fprintln(r.out, []string{"Proxy: " + request.displayProxy()})
+	}
+}
+
+func (r *tableReporter) checked(result wicResult) {
+	r.results = append(r.results, result)
+}
+
+func (r *tableReporter) done(summary wicSummary) error {
+	table := tabwriter.NewWriter(r.out, 0, 4, 2, ' ', 0)
+	switch {
+	case r.network != "":
+		fmt.Fprintln(table, "TARGET\tRESULT\tTIME\tASG\tDETAILS")
+	case r.paths:
+		fmt.Fprintln(table, "PATH\tRESULT\tTIME\tASG\tDETAILS")
+	default:
+		fmt.Fprintln(table, "PORT\tRESULT\tTIME\tASG\tDETAILS")
+	}
+	var accepting []string
+	for _, result := range r.results {
+		connect, responseTime, details := "error", "-", result.Error
+		if result.err == nil {
+			connect = "unable to connect"
+			if result.CanConnect {
+				connect = "able to connect"
+			}
+			if result.ResponseTime > 0 {
+				responseTime = fmt.Sprintf("%d ms", result.ResponseTime)
+			}
+			if result.HTTPStatus > 0 {
+				details = fmt.Sprintf("http status %d", result.HTTPStatus)
+			}
+		}
+		if result.Attempts > 1 {
+			details = strings.TrimSpace(fmt.Sprintf("%s (%d attempts)", details, result.Attempts))
+		}
+		asg := "-"
+		if result.ASG != nil {
+			asg = "blocked"
+			if result.ASG.Permitted {
+				asg = "permitted"
+			}
+		}
+		first := strconv.Itoa(result.Port)
+		switch {
+		case r.network != "":
+			first = result.Target
+		case r.paths && result.Proxy == "":
+			first = "direct"
+		case r.paths:
+			first = result.Proxy
+		}
+		if result.CanConnect {
+			accepting = append(accepting, first)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", first, connect, responseTime, asg, details)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	switch {
+	case r.network != "" && len(accepting) == 0:
+		fmt.Fprintln(r.out, "No addresses in "+r.network+" accepted connections")
+	case r.network != "":
+		fmt.Fprintln(r.out, "Accepted connections: "+strings.Join(accepting, ", "))
+	case r.paths && len(accepting) == 0 && len(r.results) > 0:
+		fmt.Fprintln(r.out, "No path could connect to "+r.results[0].Target)
+	case r.paths:
+		fmt.Fprintln(r.out, "Working paths: "+strings.Join(accepting, ", "))
+	}
+	fmt.Fprintln(r.out, summary.String())
+	return nil
+}
+
+// formatAttempts describes a result that took retries, or returns nil when the first call settled it
+func formatAttempts(result wicResult) []string {
+	if result.Attempts < 2 {
+		return nil
+	}
+	if result.err != nil {
+		return []string{fmt.Sprintf("Gave up after %d attempts", result.Attempts)}
+	}
+	return []string{fmt.Sprintf("willitconnect answered after %d attempts", result.Attempts)}
+}
+
+// structuredReporter collects every result and writes them as a single json, yaml or csv document
+type structuredReporter struct {
+	out     io.Writer
+	format  string
+	results []wicResult
+}
+
+type wicReport struct {
+	Results []wicResult `json:"results" yaml:"results"`
+	Summary wicSummary  `json:"summary" yaml:"summary"`
+}
+
+func (r *structuredReporter) checking(request *wicRequest) {}
+
+func (r *structuredReporter) checked(result wicResult) {
+	r.results = append(r.results, result)
+}
+
+func (r *structuredReporter) done(summary wicSummary) error {
+	report := wicReport{Results: r.results, Summary: summary}
+	if report.Results == nil {
+		report.Results = []wicResult{}
+	}
+
+	switch r.format {
+	case "json":
+		encoder := json.NewEncoder(r.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "yaml":
+		out, err := yaml.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = r.out.Write(out)
+		return err
+	}
+
+	writer := csv.NewWriter(r.out)
+	writer.Write(csvHeader)
+	for _, result := range r.results {
+		writer.Write(result.csvRecord())
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,87 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Output formats", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	run := func(format string) string {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponseWithTime)
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-output=" + format})
+		})
+		return strings.Join(output, "\n")
+	}
+
+	It("writes json results", func() {
+		var report map[string]interface{}
+		Expect(json.Unmarshal([]byte(run("json")), &report)).To(Succeed())
+
+		results := report["results"].([]interface{})
+		Expect(results).To(HaveLen(1))
+		result := results[0].(map[string]interface{})
+		Expect(result["target"]).To(Equal("foo.com:80"))
+		Expect(result["willItConnect"]).To(Equal(wicURL + wicPath))
+		Expect(result["canConnect"]).To(BeTrue())
+		Expect(result["httpStatus"]).To(BeNumerically("==", 200))
+		Expect(result["responseTime"]).To(BeNumerically("==", 3))
+		Expect(result["error"]).To(Equal(""))
+		Expect(report["summary"]).To(HaveKeyWithValue("canConnect", BeNumerically("==", 1)))
+	})
+
+	It("writes yaml results", func() {
+		var report struct {
+			Results []map[string]interface{} `yaml:"results"`
+		}
+		Expect(yaml.Unmarshal([]byte(run("yaml")), &report)).To(Succeed())
+		Expect(report.Results).To(HaveLen(1))
+		Expect(report.Results[0]["host"]).To(Equal("foo.com"))
+		Expect(report.Results[0]["validUrl"]).To(BeTrue())
+	})
+
+	It("writes csv results", func() {
+		lines := strings.Split(strings.TrimSpace(run("csv")), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HavePrefix("target,host,port,proxy,willItConnect,canConnect"))
+		Expect(lines[1]).To(HavePrefix("foo.com:80,foo.com,80,," + wicURL + wicPath + ",true,200"))
+	})
+
+	It("records errors in the results", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).BodyString("totes")
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-output=json"})
+		})
+		Expect(output).To(ContainSubstrings([]string{`"error": "Invalid response from willitconnect: `}))
+		Expect(output).NotTo(ContainSubstrings([]string{"Host:"}))
+	})
+
+	It("rejects unknown formats", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-output=xml"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"-output must be one of text, json, yaml, csv"}))
+	})
+})
@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Port lists", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	It("checks every listed port and displays a table", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"broker.com:5671"}`).Reply(200).JSON(goodResponseWithTime)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"broker.com:5672"}`).Reply(200).JSON(badResponse)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"broker.com:15672"}`).Reply(200).BodyString("totes")
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=broker.com", "-port=5671,5672,15672"})
+		})
+		Expect(gock.IsDone()).To(BeTrue())
+		Expect(output).To(ContainSubstrings([]string{"Host: ", "broker.com", " - WillItConnect: ", wicURL + wicPath}))
+		Expect(output).To(ContainElement(MatchRegexp(`^PORT\s+RESULT\s+TIME\s+ASG\s+DETAILS$`)))
+		Expect(output).To(ContainElement(MatchRegexp(`^5671\s+able to connect\s+3 ms\s+-\s+http status 200$`)))
+		Expect(output).To(ContainElement(MatchRegexp(`^5672\s+unable to connect\s+-\s+-\s*$`)))
+		Expect(output).To(ContainElement(MatchRegexp(`^15672\s+error\s+-\s+-\s+Invalid response from willitconnect: `)))
+		Expect(output).To(ContainSubstrings([]string{"Checked 3 targets: 1 able to connect, 1 unable to connect, 1 errors"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(4))
+	})
+
+	It("checks a port range", func() {
+		defer gock.Off()
+		for _, port := range []string{"9092", "9093", "9094"} {
+			gock.New(wicURL).Post(wicPath).JSON(`{"target":"kafka.com:` + port + `"}`).Reply(200).JSON(goodResponse)
+		}
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=kafka.com", "-port=9092-9094"})
+		})
+		Expect(gock.IsDone()).To(BeTrue())
+		Expect(output).To(ContainSubstrings([]string{"Checked 3 targets: 3 able to connect"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(0))
+	})
+
+	It("writes a result per port in structured formats", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"foo.com:80"}`).Reply(200).JSON(goodResponse)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"foo.com:443"}`).Reply(200).JSON(goodResponse)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80,443", "-output=json"})
+		})
+		var report struct {
+			Results []map[string]interface{} `json:"results"`
+		}
+		Expect(json.Unmarshal([]byte(strings.Join(output, "\n")), &report)).To(Succeed())
+		Expect(report.Results).To(HaveLen(2))
+		Expect(report.Results[1]["port"]).To(BeNumerically("==", 443))
+	})
+
+	It("rejects invalid ports and ranges", func() {
+		for _, port := range []string{"80,abc", "9094-9092", "1-65535", "0"} {
+			output := CaptureOutput(func() {
+				willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=" + port})
+			})
+			Expect(output).To(ContainSubstrings([]string{"Invalid -port: "}))
+			Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+		}
+	})
+})
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const clientProxyEnv string = "WILLITCONNECT_CLIENT_PROXY"
+
+// directProxy as -client-proxy calls willitconnect directly, ignoring HTTPS_PROXY and HTTP_PROXY
+const directProxy string = "direct"
+
+// proxySchemePorts are the proxy schemes that are supported, and their default ports
+var proxySchemePorts = map[string]string{"http": "80", "https": "443", "socks5": "1080"}
+
+// The proxy environment variables, in the order they are looked up, as curl and most other tools read them
+var httpProxyEnvs = []string{"HTTP_PROXY", "http_proxy"}
+var httpsProxyEnvs = []string{"HTTPS_PROXY", "https_proxy"}
+var noProxyEnvs = []string{"NO_PROXY", "no_proxy"}
+
+// parseProxyURL parses a proxy url, a proxy without a scheme is an http proxy
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, errors.New("not a url")
+	}
+	if _, known := proxySchemePorts[proxyURL.Scheme]; !known {
+		var schemes []string
+		for scheme := range proxySchemePorts {
+			schemes = append(schemes, scheme)
+		}
+		sort.Strings(schemes)
+		return nil, fmt.Errorf("unsupported scheme %s, use one of %s", proxyURL.Scheme, strings.Join(schemes, ", "))
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, errors.New("missing a host")
+	}
+	return proxyURL, nil
+}
+
+// clientProxy returns the proxy the plugin uses for a call to target, and where it came from: the
+// -client-proxy setting, or HTTPS_PROXY or HTTP_PROXY for the target's scheme unless NO_PROXY matches it. A
+// nil proxy calls target directly.
+func clientProxy(setting string, target *url.URL) (*url.URL, string, error) {
+	switch setting {
+	case "":
+	case directProxy:
+		return nil, "-client-proxy=" + directProxy, nil
+	default:
+		proxy, err := parseProxyURL(setting)
+		return proxy, "-client-proxy", err
+	}
+
+	names := httpProxyEnvs
+	if target.Scheme == "https" {
+		names = httpsProxyEnvs
+	}
+	name, value := lookupEnv(names)
+	if value == "" {
+		return nil, "", nil
+	}
+	if noProxyName, noProxy := lookupEnv(noProxyEnvs); noProxyMatches(noProxy, target.Hostname(), urlPort(target)) {
+		return nil, noProxyName + " matches " + target.Hostname(), nil
+	}
+	proxy, err := parseProxyURL(value)
+	if err != nil {
+		return nil, name, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return proxy, name, nil
+}
+
+// describeClientProxy says how the plugin reaches wicURL, or nothing when no proxy is set
+func describeClientProxy(setting string, wicURL string) string {
+	target, err := url.Parse(wicURL)
+	if err != nil {
+		return ""
+	}
+	proxy, source, err := clientProxy(setting, target)
+	switch {
+	case err != nil:
+		return "Unable to use the proxy for willitconnect, " + err.Error()
+	case proxy != nil:
+		return "Reaching willitconnect through proxy " + proxy.Redacted() + " from " + source
+	case source != "":
+		return "Reaching willitconnect directly, " + source
+	}
+	return ""
+}
+
+// hasClientProxy reports whether the plugin may use a proxy to reach willitconnect
+func hasClientProxy(setting string) bool {
+	if setting != "" {
+		return true
+	}
+	_, httpProxy := lookupEnv(httpProxyEnvs)
+	_, httpsProxy := lookupEnv(httpsProxyEnvs)
+	return httpProxy != "" || httpsProxy != ""
+}
+
+// clientProxyFunc is clientProxy as an http.Transport's Proxy
+func clientProxyFunc(setting string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxy, _, err := clientProxy(setting, req.URL)
+		return proxy, err
+	}
+}
+
+// noProxyMatches reports whether a NO_PROXY style list matches host and port. Entries are *, an ip or CIDR
+// block, or a domain that also matches its subdomains, optionally with a port.
+func noProxyMatches(noProxy string, host string, port string) bool {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if splitHost, splitPort, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = splitHost, splitPort
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(strings.Trim(entryHost, "[]"), "*"), ".")
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupEnv returns the first of the environment variables that is set, and its value
+func lookupEnv(names []string) (string, string) {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return name, value
+		}
+	}
+	return "", ""
+}
+
+// urlPort is the port of a url, or the default port for its scheme
+func urlPort(target *url.URL) string {
+	if port := target.Port(); port != "" {
+		return port
+	}
+	if target.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// targetProxy is a proxy willitconnect connects to targets through, except targets its noProxy list matches
+type targetProxy struct {
+	scheme  string
+	user    *url.Userinfo
+	host    string
+	port    int
+	noProxy string
+}
+
+// parseTargetProxy parses -proxy, defaulting its port for its scheme
+func parseTargetProxy(proxy string) (*targetProxy, error) {
+	proxyURL, err := parseProxyURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+	port := proxyURL.Port()
+	if port == "" {
+		port = proxySchemePorts[proxyURL.Scheme]
+	}
+	portNumber, err := parsePort(port)
+	if err != nil {
+		return nil, err
+	}
+	return &targetProxy{scheme: proxyURL.Scheme, user: proxyURL.User, host: proxyURL.Hostname(), port: portNumber}, nil
+}
+
+// bypasses reports whether the noProxy list matches a target, which may be a url
+func (p *targetProxy) bypasses(host string, port int) bool {
+	if p.noProxy == "" {
+		return false
+	}
+	if strings.Contains(host, "://") {
+		if parsed, err := url.Parse(host); err == nil {
+			host = parsed.Hostname()
+		}
+	}
+	return noProxyMatches(p.noProxy, host, strconv.Itoa(port))
+}
+
+// parseProxyFlags returns the proxy willitconnect connects through, from -proxy or from -proxyHost and
+// -proxyPort, which must be given together. It returns nil when there is no proxy.
+func parseProxyFlags(proxy string, proxyHost string, proxyPort int, noProxy string) (*targetProxy, []string) {
+	var parsed *targetProxy
+	switch {
+	case proxy != "" && (proxyHost != "" || proxyPort != -1):
+		return nil, []string{"-proxy cannot be combined with -proxyHost or -proxyPort"}
+	case proxy != "":
+		var err error
+		if parsed, err = parseTargetProxy(proxy); err != nil {
+			return nil, []string{"Invalid -proxy: ", err.Error()}
+		}
+	case (proxyHost == "") != (proxyPort == -1):
+		return nil, []string{"-proxyHost and -proxyPort must be given together, or use -proxy=<url>"}
+	case proxyHost != "":
+		parsed = &targetProxy{scheme: "http", host: proxyHost, port: proxyPort}
+	case noProxy != "":
+		return nil, []string{"-no-proxy needs a proxy, use -proxy=<url> or -proxyHost and -proxyPort"}
+	default:
+		return nil, nil
+	}
+	parsed.noProxy = noProxy
+	return parsed, nil
+}
+
+// proxyCandidates returns the proxies -find-proxy tries: -proxies, the lines of -proxies-file, which may
+// have blank lines and # comments, or the proxies in the config
+func proxyCandidates(list string, file string, configured []string) ([]*targetProxy, []string) {
+	var candidates []string
+	switch {
+	case list != "":
+		candidates = strings.Split(list, ",")
+	case file != "":
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, []string{"Unable to read proxies file: ", err.Error()}
+		}
+		for _, line := range strings.Split(string(contents), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				candidates = append(candidates, line)
+			}
+		}
+	default:
+		candidates = configured
+	}
+	if len(candidates) == 0 {
+		return nil, []string{"-find-proxy needs candidate proxies from -proxies, -proxies-file or proxies in the config"}
+	}
+
+	var proxies []*targetProxy
+	for i, candidate := range candidates {
+		proxy, err := parseTargetProxy(strings.TrimSpace(candidate))
+		if err != nil {
+			return nil, []string{fmt.Sprintf("Invalid candidate proxy %d: ", i+1), err.Error()}
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
@@ -0,0 +1,49 @@
+package main_test
+
+import (
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Error responses", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	It("explains a missing willitconnect route", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(404).SetHeader("X-Cf-Routererror", "unknown_route").
+			BodyString("404 Not Found: Requested route ('willitconnect.cfapps.io') does not exist.")
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"willitconnect responded with 404 Not Found, the route is missing",
+			"does not exist.", "Deploy willitconnect with cf wic-deploy or -ensure"}))
+		Expect(output).NotTo(ContainSubstrings([]string{"Invalid response from willitconnect"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(4))
+	})
+
+	It("explains a route that requires authentication", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(401)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"willitconnect responded with 401 Unauthorized, authentication is required",
+			"pass credentials with -cf-token, -username and -password, or -client-cert"}))
+	})
+})
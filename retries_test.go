@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("Retries", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	It("retries a 502 from the gorouter and reports the attempts", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(502)
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Reply(200).JSON(goodResponse)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+		Expect(output).To(ContainSubstrings([]string{"willitconnect answered after 2 attempts"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(0))
+	})
+
+	It("gives up after -retries", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(goodRequest).Times(2).Reply(503)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-retries=1", "-output=json"})
+		})
+		var report struct {
+			Results []map[string]interface{} `json:"results"`
+		}
+		Expect(json.Unmarshal([]byte(strings.Join(output, "\n")), &report)).To(Succeed())
+		Expect(report.Results[0]["attempts"]).To(BeNumerically("==", 2))
+		Expect(report.Results[0]["error"]).To(HavePrefix("willitconnect responded with 503 Service Unavailable, the app is crashed or unavailable. "))
+		Expect(gock.IsDone()).To(BeTrue())
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(4))
+	})
+
+	It("doesn't retry a target that can't connect", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(badRequest).Reply(200).JSON(badResponse)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=bar.com", "-port=80"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"I am unable to connect"}))
+		Expect(output).NotTo(ContainSubstrings([]string{"attempts"}))
+	})
+
+	It("rejects an invalid -timeout", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=foo.com", "-port=80", "-timeout=0s"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"-timeout must be more than 0, such as 30s"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+})
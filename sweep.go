@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// maxSweepAddresses caps the size of a -host CIDR block, and sweepParallel is how many of its addresses
+// are checked at once
+const maxSweepAddresses = 256
+const sweepParallel = 8
+
+// maxSweepChecks caps the addresses times ports a sweep checks, so a full block can't be paired with a wide
+// port range
+const maxSweepChecks = 1024
+
+// isCIDR reports whether a -host is a CIDR block such as 10.20.30.0/28 rather than a host or url
+func isCIDR(host string) bool {
+	if strings.Contains(host, "://") {
+		return false
+	}
+	_, _, err := net.ParseCIDR(host)
+	return err == nil
+}
+
+// expandCIDR lists every address in a CIDR block, including its network and broadcast addresses
+func expandCIDR(cidr string) ([]string, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid CIDR block", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 16 || 1<<uint(bits-ones) > maxSweepAddresses {
+		return nil, fmt.Errorf("%s is larger than %d addresses, sweep a smaller block", cidr, maxSweepAddresses)
+	}
+
+	var addresses []string
+	for ip := network.IP.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+		addresses = append(addresses, ip.String())
+	}
+	return addresses, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -0,0 +1,90 @@
+package main_test
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/h2non/gock.v0"
+)
+
+var _ = Describe("CIDR sweeps", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+	})
+
+	It("checks every address in the block and summarizes which accepted connections", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"10.20.30.0:443"}`).Reply(200).JSON(badResponse)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"10.20.30.1:443"}`).Reply(200).JSON(goodResponseWithTime)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"10.20.30.2:443"}`).Reply(200).JSON(badResponse)
+		gock.New(wicURL).Post(wicPath).JSON(`{"target":"10.20.30.3:443"}`).Reply(200).JSON(goodResponse)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.20.30.0/30", "-port=443"})
+		})
+		Expect(gock.IsDone()).To(BeTrue())
+		Expect(output).To(ContainSubstrings([]string{"Network: ", "10.20.30.0/30"}))
+		Expect(output).To(ContainElement(MatchRegexp(`^TARGET\s+RESULT`)))
+		Expect(output).To(ContainElement(MatchRegexp(`^10.20.30.0:443\s+unable to connect`)))
+		Expect(output).To(ContainElement(MatchRegexp(`^10.20.30.1:443\s+able to connect\s+3 ms`)))
+		Expect(output).To(ContainSubstrings([]string{"Accepted connections: 10.20.30.1:443, 10.20.30.3:443"}))
+		Expect(output).To(ContainSubstrings([]string{"Checked 4 targets: 2 able to connect, 2 unable to connect, 0 errors"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(1))
+	})
+
+	It("reports the results in order when checked concurrently", func() {
+		defer gock.Off()
+		gock.New(wicURL).Post(wicPath).Times(16).Reply(200).JSON(badResponse)
+
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.20.30.0/28", "-port=443"})
+		})
+		var targets, expected []string
+		for _, line := range output {
+			if strings.HasPrefix(line, "10.20.30.") {
+				targets = append(targets, strings.Fields(line)[0])
+			}
+		}
+		for i := 0; i < 16; i++ {
+			expected = append(expected, fmt.Sprintf("10.20.30.%d:443", i))
+		}
+		Expect(targets).To(Equal(expected))
+		Expect(output).To(ContainSubstrings([]string{"No addresses in 10.20.30.0/28 accepted connections"}))
+	})
+
+	It("limits the size of the block", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.0/16", "-port=443"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"10.0.0.0/16 is larger than 256 addresses, sweep a smaller block"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("limits the number of checks across the block and ports", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.0.0.0/24", "-port=1-1024"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"10.0.0.0/24 on 1024 ports is more than 1024 checks, sweep a smaller block or fewer ports"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+
+	It("requires a port", func() {
+		output := CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, []string{"willitconnect", "-host=10.20.30.0/30"})
+		})
+		Expect(output).To(ContainSubstrings([]string{"Usage: cf willitconnect -host=<cidr> -port=<port>"}))
+	})
+})
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/cloudfoundry/cli/plugin"
+)
+
+const caCertEnv string = "WILLITCONNECT_CA_CERT"
+
+// transportSettings are the TLS files and proxy used for calls to willitconnect
+type transportSettings struct {
+	caCert     string
+	clientCert string
+	clientKey  string
+	proxy      string
+}
+
+// newTransport returns the transport for calls to willitconnect, skipping certificate validation when the
+// cli does (cf api --skip-ssl-validation), trusting the certificates in the CA bundle along with the
+// system's, presenting a client certificate for mTLS, and going through the -client-proxy or the proxy in
+// the environment. The client key may be in the client certificate's file. It returns nil, so calls use
+// http.DefaultTransport, when none of these apply.
+func newTransport(cliConnection plugin.CliConnection, settings transportSettings) (http.RoundTripper, []string) {
+	skipValidation, _ := cliConnection.IsSSLDisabled()
+	if !skipValidation && settings.caCert == "" && settings.clientCert == "" && !hasClientProxy(settings.proxy) {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: skipValidation}
+	if settings.caCert != "" {
+		bundle, err := ioutil.ReadFile(settings.caCert)
+		if err != nil {
+			return nil, []string{"Unable to read CA bundle: ", err.Error()}
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, []string{"No PEM certificates found in CA bundle " + settings.caCert}
+		}
+		config.RootCAs = pool
+	}
+	if settings.clientCert != "" {
+		clientKey := settings.clientKey
+		if clientKey == "" {
+			clientKey = settings.clientCert
+		}
+		certificate, err := tls.LoadX509KeyPair(settings.clientCert, clientKey)
+		if err != nil {
+			return nil, []string{"Unable to load client certificate: ", err.Error()}
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if base, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = base.Clone()
+	}
+	transport.TLSClientConfig = config
+	transport.Proxy = clientProxyFunc(settings.proxy)
+	return transport, nil
+}
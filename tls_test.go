@@ -0,0 +1,84 @@
+package main_test
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/cloudfoundry/cli/plugin/models"
+	"github.com/cloudfoundry/cli/plugin/pluginfakes"
+	. "github.com/cloudfoundry/cli/testhelpers/io"
+	. "github.com/cloudfoundry/cli/testhelpers/matchers"
+	. "github.com/gambtho/cf_will_it_connect_plugin"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS", func() {
+	var fakeCliConnection *pluginfakes.FakeCliConnection
+	var willItConnectPlugin *WillItConnect
+	var server *httptest.Server
+	var caCert string
+
+	BeforeEach(func() {
+		fakeCliConnection = &pluginfakes.FakeCliConnection{}
+		willItConnectPlugin = &WillItConnect{}
+		fakeCliConnection.GetOrgReturns(plugin_models.GetOrg_Model{Domains: []plugin_models.GetOrg_Domains{plugin_models.GetOrg_Domains{Name: "cfapps.io"}}}, nil)
+		fakeCliConnection.GetCurrentOrgReturns(plugin_models.Organization{OrganizationFields: plugin_models.OrganizationFields{Name: "org"}}, nil)
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(goodResponse))
+		}))
+		file, err := ioutil.TempFile("", "wic-ca")
+		Expect(err).NotTo(HaveOccurred())
+		pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		file.Close()
+		caCert = file.Name()
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Remove(caCert)
+		os.Unsetenv("WILLITCONNECT_CA_CERT")
+	})
+
+	run := func(args ...string) []string {
+		return CaptureOutput(func() {
+			willItConnectPlugin.Run(fakeCliConnection, append([]string{"willitconnect", "-host=foo.com", "-port=80", "-route=" + server.URL, "-retries=0"}, args...))
+		})
+	}
+
+	It("distinguishes an untrusted certificate from an unreachable willitconnect", func() {
+		output := run()
+		Expect(output).To(ContainSubstrings([]string{"Unable to verify willitconnect's certificate: ", "certificate signed by unknown authority",
+			"Pass your foundation's CA bundle with -ca-cert or WILLITCONNECT_CA_CERT"}))
+		Expect(output).NotTo(ContainSubstrings([]string{"Unable to access willitconnect"}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(4))
+	})
+
+	It("trusts a CA bundle from -ca-cert", func() {
+		output := run("-ca-cert=" + caCert)
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("trusts a CA bundle from WILLITCONNECT_CA_CERT", func() {
+		os.Setenv("WILLITCONNECT_CA_CERT", caCert)
+		output := run()
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("skips validation when the cli does", func() {
+		fakeCliConnection.IsSSLDisabledReturns(true, nil)
+		output := run()
+		Expect(output).To(ContainSubstrings([]string{"I am able to connect"}))
+	})
+
+	It("rejects a CA bundle without certificates", func() {
+		ioutil.WriteFile(caCert, []byte("not a certificate"), 0600)
+		output := run("-ca-cert=" + caCert)
+		Expect(output).To(ContainSubstrings([]string{"No PEM certificates found in CA bundle " + caCert}))
+		Expect(willItConnectPlugin.ExitCode()).To(Equal(2))
+	})
+})
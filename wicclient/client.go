@@ -0,0 +1,399 @@
+// Package wicclient is a client for the willitconnect API, which checks whether the CF instance
+// it runs on can connect to a target, optionally through a proxy.
+package wicclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Path is the willitconnect API endpoint, relative to the willitconnect route
+const Path string = "/v2/willitconnect"
+
+// CheckRequest is a target for willitconnect to connect to. Host may be a url, in which case
+// willitconnect makes an http connection rather than opening a socket.
+type CheckRequest struct {
+	Host      string
+	Port      int
+	ProxyHost string
+	ProxyPort int
+	// ProxyScheme is http, which is assumed when it is empty, https or socks5
+	ProxyScheme string
+	// ProxyUser holds the proxy's credentials, when it needs them
+	ProxyUser *url.Userinfo
+}
+
+// HasProxy reports whether willitconnect should connect through a proxy
+func (r CheckRequest) HasProxy() bool {
+	return r.ProxyHost != ""
+}
+
+// Target is what willitconnect connects to: host:port, or for a url host the url with Port in place of
+// any port it names, keeping its path and query
+func (r CheckRequest) Target() string {
+	port := strconv.Itoa(r.Port)
+	if strings.Contains(r.Host, "://") {
+		if parsed, err := url.Parse(r.Host); err == nil && parsed.Host != "" {
+			parsed.Host = net.JoinHostPort(parsed.Hostname(), port)
+			return parsed.String()
+		}
+	}
+	return net.JoinHostPort(strings.Trim(r.Host, "[]"), port)
+}
+
+// Proxy is the proxyHost:proxyPort willitconnect connects through
+func (r CheckRequest) Proxy() string {
+	return net.JoinHostPort(strings.Trim(r.ProxyHost, "[]"), strconv.Itoa(r.ProxyPort))
+}
+
+// ProxyURL is the proxy willitconnect connects through as a url, with its scheme and credentials
+func (r CheckRequest) ProxyURL() *url.URL {
+	scheme := r.ProxyScheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return &url.URL{Scheme: scheme, User: r.ProxyUser, Host: r.Proxy()}
+}
+
+// isPlainProxy reports whether the proxy is an http proxy without credentials, which is sent as
+// proxyHost:proxyPort as willitconnect has always accepted it
+func (r CheckRequest) isPlainProxy() bool {
+	return (r.ProxyScheme == "" || r.ProxyScheme == "http") && r.ProxyUser == nil
+}
+
+// Validate reports an InvalidRequestError for a request willitconnect can't be asked about: a missing host,
+// ports outside 1-65535, or hosts that aren't valid UTF-8 or contain whitespace or control characters
+func (r CheckRequest) Validate() error {
+	if err := validateHost("host", r.Host); err != nil {
+		return err
+	}
+	if err := validatePort("port", r.Port); err != nil {
+		return err
+	}
+	if !r.HasProxy() {
+		return nil
+	}
+	if err := validateHost("proxy host", r.ProxyHost); err != nil {
+		return err
+	}
+	switch r.ProxyScheme {
+	case "", "http", "https", "socks5":
+	default:
+		return &InvalidRequestError{Field: "proxy scheme", Value: r.ProxyScheme, Reason: "must be http, https or socks5"}
+	}
+	return validatePort("proxy port", r.ProxyPort)
+}
+
+func validateHost(field string, host string) error {
+	if host == "" {
+		return &InvalidRequestError{Field: field, Value: host, Reason: "is empty"}
+	}
+	if !utf8.ValidString(host) {
+		return &InvalidRequestError{Field: field, Value: host, Reason: "is not valid UTF-8"}
+	}
+	for _, r := range host {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return &InvalidRequestError{Field: field, Value: host, Reason: "contains whitespace or control characters"}
+		}
+	}
+	return nil
+}
+
+func validatePort(field string, port int) error {
+	if port < 1 || port > 65535 {
+		return &InvalidRequestError{Field: field, Value: strconv.Itoa(port), Reason: "must be between 1 and 65535"}
+	}
+	return nil
+}
+
+// checkPayload is the body of a willitconnect check
+type checkPayload struct {
+	Target    string `json:"target"`
+	HTTPProxy string `json:"http_proxy,omitempty"`
+}
+
+func newCheckPayload(request CheckRequest) checkPayload {
+	payload := checkPayload{Target: request.Target()}
+	if request.HasProxy() {
+		payload.HTTPProxy = request.Proxy()
+		if !request.isPlainProxy() {
+			payload.HTTPProxy = request.ProxyURL().String()
+		}
+	}
+	return payload
+}
+
+// CheckResult is willitconnect's answer for a target
+type CheckResult struct {
+	LastChecked   int    `json:"lastChecked"`
+	Entry         string `json:"entry"`
+	CanConnect    bool   `json:"canConnect"`
+	HTTPStatus    int    `json:"httpStatus"`
+	ValidHostname bool   `json:"validHostname"`
+	ValidURL      bool   `json:"validUrl"`
+	ResponseTime  int    `json:"responseTime,omitempty"`
+
+	// Attempts is how many calls the Client made to get this result
+	Attempts int `json:"-"`
+}
+
+// InvalidRequestError is returned for a request that can't be sent to willitconnect
+type InvalidRequestError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidRequestError) Error() string {
+	return fmt.Sprintf("Invalid %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// UnreachableError is returned when willitconnect itself could not be reached
+type UnreachableError struct {
+	URL      string
+	Err      error
+	Attempts int
+}
+
+func (e *UnreachableError) Error() string {
+	return "Unable to access willitconnect: " + e.Err.Error()
+}
+
+// TLSError is returned when willitconnect's certificate couldn't be verified, which retrying won't fix
+type TLSError struct {
+	URL      string
+	Err      error
+	Attempts int
+}
+
+func (e *TLSError) Error() string {
+	return "Unable to verify willitconnect's certificate: " + e.Err.Error()
+}
+
+// InvalidResponseError is returned when willitconnect's answer could not be understood
+type InvalidResponseError struct {
+	Err      error
+	Attempts int
+}
+
+func (e *InvalidResponseError) Error() string {
+	return "Invalid response from willitconnect: " + e.Err.Error()
+}
+
+// StatusKind classifies the non-2xx statuses willitconnect's route can respond with
+type StatusKind string
+
+// The kinds of StatusError
+const (
+	// RouteMissing is a 404, or the gorouter reporting that no app is mapped to the route
+	RouteMissing StatusKind = "the route is missing"
+	// AuthRequired is a 401 or 403, usually from an authenticating proxy or route service
+	AuthRequired StatusKind = "authentication is required"
+	// AppUnavailable is a 502, 503 or 504, usually a crashed or restarting app
+	AppUnavailable StatusKind = "the app is crashed or unavailable"
+	// ServerError is any other 5xx, willitconnect failed to handle the request
+	ServerError StatusKind = "the app failed to handle the request"
+	// UnexpectedStatus is any other non-2xx status
+	UnexpectedStatus StatusKind = "the status was unexpected"
+)
+
+// maxSnippet is how much of a non-2xx response body a StatusError keeps
+const maxSnippet = 120
+
+// StatusError is returned when willitconnect's route responds with a non-2xx status, 5xx statuses are
+// returned after any retries
+type StatusError struct {
+	StatusCode int
+	// RouterError is the gorouter's X-Cf-Routererror header, such as unknown_route, when it set one
+	RouterError string
+	// Body is the start of the response body with its whitespace collapsed
+	Body     string
+	Attempts int
+}
+
+// Kind classifies the status so callers can suggest a fix
+func (e *StatusError) Kind() StatusKind {
+	switch {
+	case e.RouterError == "unknown_route" || e.StatusCode == http.StatusNotFound:
+		return RouteMissing
+	case e.RouterError == "endpoint_failure":
+		return AppUnavailable
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return AuthRequired
+	case e.StatusCode == http.StatusBadGateway || e.StatusCode == http.StatusServiceUnavailable ||
+		e.StatusCode == http.StatusGatewayTimeout:
+		return AppUnavailable
+	case e.StatusCode >= 500:
+		return ServerError
+	}
+	return UnexpectedStatus
+}
+
+func (e *StatusError) Error() string {
+	message := fmt.Sprintf("willitconnect responded with %d %s, %s", e.StatusCode, http.StatusText(e.StatusCode), e.Kind())
+	if e.Body != "" {
+		message += fmt.Sprintf(" (%q)", e.Body)
+	}
+	return message
+}
+
+// snippet collapses the whitespace of the start of a response body and shortens it to maxSnippet characters
+func snippet(body io.Reader) string {
+	start, _ := ioutil.ReadAll(io.LimitReader(body, 4*maxSnippet))
+	collapsed := []rune(strings.Join(strings.Fields(string(start)), " "))
+	if len(collapsed) > maxSnippet {
+		return string(collapsed[:maxSnippet]) + "..."
+	}
+	return string(collapsed)
+}
+
+// DefaultBackoff is how long a Client waits before its first retry when Backoff isn't set
+const DefaultBackoff = 500 * time.Millisecond
+
+// Client checks targets against a willitconnect deployment
+type Client struct {
+	// URL is the willitconnect API endpoint, such as https://willitconnect.example.com/v2/willitconnect
+	URL string
+	// HTTPClient makes the calls to willitconnect, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Retries is how many more times a call is made when willitconnect can't be reached or responds with a
+	// 5xx status, such as while it restarts. Answers from willitconnect, including that it can't connect,
+	// are never retried.
+	Retries int
+	// Backoff is how long to wait before the first retry, doubling for each retry after it
+	Backoff time.Duration
+	// Limit, when set, is received from before each retry, so retries share the caller's rate limit
+	Limit <-chan time.Time
+	// Token, when set, is sent as a bearer token for willitconnect deployments behind an auth proxy or
+	// route service. It may already have its bearer prefix, as cf oauth-token prints it.
+	Token string
+	// Username and Password, when Username is set, are sent with basic auth unless there is a Token
+	Username string
+	Password string
+}
+
+// New returns a Client for the willitconnect API endpoint at url
+func New(url string) *Client {
+	return &Client{URL: url}
+}
+
+// Check asks willitconnect whether it can connect to the request's target, retrying as configured. Requests
+// that fail Validate are not sent.
+func (c *Client) Check(ctx context.Context, request CheckRequest) (*CheckResult, error) {
+	if err := request.Validate(); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(newCheckPayload(request))
+	if err != nil {
+		return nil, &InvalidRequestError{Field: "target", Value: request.Target(), Reason: err.Error()}
+	}
+
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		result, err := c.check(ctx, payload, attempt)
+		if err == nil || attempt > c.Retries || !retryable(ctx, err) {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		if c.Limit != nil {
+			select {
+			case <-ctx.Done():
+				return nil, err
+			case <-c.Limit:
+			}
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) check(ctx context.Context, payload []byte, attempt int) (*CheckResult, error) {
+	req, err := http.NewRequest("POST", c.URL, bytes.NewBuffer(payload))
+	if err != nil {
+		return nil, &InvalidRequestError{Field: "willitconnect url", Value: c.URL, Reason: err.Error()}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", bearer(c.Token))
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil && isTLSFailure(err) {
+		return nil, &TLSError{URL: c.URL, Err: err, Attempts: attempt}
+	}
+	if err != nil {
+		return nil, &UnreachableError{URL: c.URL, Err: err, Attempts: attempt}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, RouterError: resp.Header.Get("X-Cf-Routererror"),
+			Body: snippet(resp.Body), Attempts: attempt}
+	}
+
+	var result CheckResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, &InvalidResponseError{Err: err, Attempts: attempt}
+	}
+	result.Attempts = attempt
+	return &result, nil
+}
+
+func bearer(token string) string {
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		return "Bearer " + token[len("bearer "):]
+	}
+	return "Bearer " + token
+}
+
+// isTLSFailure reports whether a call failed because the server's certificate couldn't be verified or the
+// server didn't speak TLS, rather than because it couldn't be reached
+func isTLSFailure(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	var recordHeader tls.RecordHeaderError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &verification) || errors.As(err, &recordHeader)
+}
+
+// retryable reports whether a failed call is worth making again: willitconnect couldn't be reached, for a
+// reason other than ctx ending, or it responded with a 5xx status
+func retryable(ctx context.Context, err error) bool {
+	switch err := err.(type) {
+	case *UnreachableError:
+		return ctx.Err() == nil
+	case *StatusError:
+		return err.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package wicclient_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestWicclient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Wicclient Suite")
+}